@@ -6,7 +6,13 @@
 package efp
 
 import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -44,6 +50,7 @@ const (
 	TokenTypeOperatorPostfix = "OperatorPostfix" //类型:操作符后缀
 	TokenTypeWhitespace      = "Whitespace"      //类型:空白
 	TokenTypeUnknown         = "Unknown"         //类型:未知
+	TokenTypeEOF             = "EOF"             //类型:结束标记
 
 	// Token subtypes
 	TokenSubTypeNothing       = "Nothing"       //子类型:无
@@ -58,14 +65,197 @@ const (
 	TokenSubTypeConcatenation = "Concatenation" //子类型:连接符
 	TokenSubTypeIntersection  = "Intersection"  //子类型:交集
 	TokenSubTypeUnion         = "Union"         //子类型:联合
+	TokenSubTypeName          = "Name"          //子类型:名称
+	TokenSubTypeSpill         = "Spill"         //子类型:溢出
+	TokenSubTypeCell          = "Cell"          //子类型:单元格
+	TokenSubTypeColumn        = "Column"        //子类型:整列
+	TokenSubTypeRow           = "Row"           //子类型:整行
+	TokenSubType3DRange       = "3DRange"       //子类型:跨工作表范围
+	TokenSubTypePercent       = "Percent"       //子类型:百分数
 )
 
+var (
+	// referencePattern matches A1 and R1C1 style cell, range, column and row
+	// references without their sheet prefix.
+	referencePattern = regexp.MustCompile(`^(?i)(\$?[A-Z]{1,3}\$?[0-9]+(:\$?[A-Z]{1,3}\$?[0-9]+)?|\$?[A-Z]{1,3}:\$?[A-Z]{1,3}|\$?[0-9]+:\$?[0-9]+|R(\[-?[0-9]+\]|[0-9]+)?C(\[-?[0-9]+\]|[0-9]+)?(:R(\[-?[0-9]+\]|[0-9]+)?C(\[-?[0-9]+\]|[0-9]+)?)?)$`)
+	// scientificPattern matches the mantissa and exponent marker of a number
+	// in scientific notation, before the sign of the exponent.
+	scientificPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[Ee]$`)
+	// cellPattern matches a single A1 style cell reference.
+	cellPattern = regexp.MustCompile(`^(?i)\$?[A-Z]{1,3}\$?[0-9]+$`)
+	// r1c1CellPattern matches a single R1C1 style cell reference.
+	r1c1CellPattern = regexp.MustCompile(`^(?i)R(\[-?[0-9]+\]|[0-9]+)?C(\[-?[0-9]+\]|[0-9]+)?$`)
+	// r1c1EndpointPattern matches an R1C1 style cell, whole row or whole
+	// column, capturing the row and column parts.
+	r1c1EndpointPattern = regexp.MustCompile(`^(?i)(R(?:\[-?[0-9]+\]|[0-9]+)?)?(C(?:\[-?[0-9]+\]|[0-9]+)?)?$`)
+	// columnPattern matches an A1 style whole column reference.
+	columnPattern = regexp.MustCompile(`^(?i)\$?[A-Z]{1,3}:\$?[A-Z]{1,3}$`)
+	// rowPattern matches an A1 style whole row reference.
+	rowPattern = regexp.MustCompile(`^\$?[0-9]+:\$?[0-9]+$`)
+	// sheetNamePattern matches sheet names which need no quotes.
+	sheetNamePattern = regexp.MustCompile(`^[\pL_][\pL0-9_.]*$`)
+	// openRangePattern matches the open ended ranges of Google Sheets, from a
+	// cell to the end of a column or row such as A2:A or A2:2.
+	openRangePattern = regexp.MustCompile(`^(?i)\$?[A-Z]{1,3}\$?[0-9]+:(\$?[A-Z]{1,3}|\$?[0-9]+)$`)
+	// workbookPattern matches the workbook prefix of an unquoted sheet name,
+	// such as "[1]" or "[Book1.xlsx]".
+	workbookPattern = regexp.MustCompile(`^\[[^\[\]]+\]`)
+	// structuredPattern matches a structured reference to an Excel table,
+	// such as Table1[Amount], [@[Sales Amount]] or @Table1[Amount].
+	structuredPattern = regexp.MustCompile(`^@?([\pL_\\][\pL0-9_.\\]*)?\[.*\]$`)
+	// namePattern matches defined names without their sheet prefix.
+	namePattern = regexp.MustCompile(`^[\pL_\\][\pL0-9_.\\?]*$`)
+)
+
+// maxColumns and maxRows are the size of an Excel worksheet.
+const (
+	maxColumns = 16384
+	maxRows    = 1048576
+)
+
+// errorValues lists the error literals recognized by the parser.
+var errorValues = map[string]struct{}{
+	"#NULL!": {}, "#DIV/0!": {}, "#VALUE!": {}, "#REF!": {}, "#NAME?": {}, "#NUM!": {}, "#N/A": {},
+}
+
+// FunctionPrefixes lists the prefixes stripped from function names during
+// parse, such as the "_xlfn." Excel stores in front of functions newer than
+// the file format. They are tried in order and only the first match is
+// removed, so longer prefixes must come first.
+// 解析时从函数名中去掉的前缀
+var FunctionPrefixes = []string{"_xlfn._xlws.", "_xlfn.", "_xll."}
+
+// ExternalDataFunctions lists the upper case names of the functions which
+// pull data from outside the workbook, used by UsesExternalData.
+// 从工作簿外部获取数据的函数
+var ExternalDataFunctions = map[string]struct{}{
+	"WEBSERVICE": {}, "RTD": {}, "FILTERXML": {}, "IMPORTDATA": {}, "HYPERLINK": {},
+}
+
+// SpillFunctions lists the upper case names of the dynamic array functions
+// whose results spill into neighbouring cells, used by SpillsArray.
+// 返回动态数组的函数
+var SpillFunctions = map[string]struct{}{
+	"SEQUENCE": {}, "FILTER": {}, "SORT": {}, "SORTBY": {}, "UNIQUE": {}, "RANDARRAY": {},
+}
+
+// BuiltinFunctions lists the upper case names of the worksheet functions
+// built into Excel. Parse reads one of them followed by whitespace and "(",
+// such as SUM in =SUM (A1), as a function call rather than an intersection.
+// 内置的工作表函数
+var BuiltinFunctions = map[string]struct{}{
+	"ABS": {}, "ACCRINT": {}, "ACCRINTM": {}, "ACOS": {}, "ACOSH": {}, "ACOT": {}, "ACOTH": {},
+	"ADDRESS": {}, "AGGREGATE": {}, "AMORDEGRC": {}, "AMORLINC": {}, "AND": {}, "ARABIC": {},
+	"AREAS": {}, "ARRAYTOTEXT": {}, "ASC": {}, "ASIN": {}, "ASINH": {}, "ATAN": {}, "ATAN2": {},
+	"ATANH": {}, "AVEDEV": {}, "AVERAGE": {}, "AVERAGEA": {}, "AVERAGEIF": {}, "AVERAGEIFS": {},
+	"BAHTTEXT": {}, "BASE": {}, "BESSELI": {}, "BESSELJ": {}, "BESSELK": {}, "BESSELY": {},
+	"BETA.DIST": {}, "BETA.INV": {}, "BETADIST": {}, "BETAINV": {}, "BIN2DEC": {}, "BIN2HEX": {},
+	"BIN2OCT": {}, "BINOM.DIST": {}, "BINOM.DIST.RANGE": {}, "BINOM.INV": {}, "BINOMDIST": {},
+	"BITAND": {}, "BITLSHIFT": {}, "BITOR": {}, "BITRSHIFT": {}, "BITXOR": {}, "BYCOL": {},
+	"BYROW": {}, "CALL": {}, "CEILING": {}, "CEILING.MATH": {}, "CEILING.PRECISE": {}, "CELL": {},
+	"CHAR": {}, "CHIDIST": {}, "CHIINV": {}, "CHISQ.DIST": {}, "CHISQ.DIST.RT": {}, "CHISQ.INV": {},
+	"CHISQ.INV.RT": {}, "CHISQ.TEST": {}, "CHITEST": {}, "CHOOSE": {}, "CHOOSECOLS": {},
+	"CHOOSEROWS": {}, "CLEAN": {}, "CODE": {}, "COLUMN": {}, "COLUMNS": {}, "COMBIN": {},
+	"COMBINA": {}, "COMPLEX": {}, "CONCAT": {}, "CONCATENATE": {}, "CONFIDENCE": {},
+	"CONFIDENCE.NORM": {}, "CONFIDENCE.T": {}, "CONVERT": {}, "CORREL": {}, "COS": {}, "COSH": {},
+	"COT": {}, "COTH": {}, "COUNT": {}, "COUNTA": {}, "COUNTBLANK": {}, "COUNTIF": {}, "COUNTIFS": {},
+	"COUPDAYBS": {}, "COUPDAYS": {}, "COUPDAYSNC": {}, "COUPNCD": {}, "COUPNUM": {}, "COUPPCD": {},
+	"COVAR": {}, "COVARIANCE.P": {}, "COVARIANCE.S": {}, "CRITBINOM": {}, "CSC": {}, "CSCH": {},
+	"CUBEKPIMEMBER": {}, "CUBEMEMBER": {}, "CUBEMEMBERPROPERTY": {}, "CUBERANKEDMEMBER": {},
+	"CUBESET": {}, "CUBESETCOUNT": {}, "CUBEVALUE": {}, "CUMIPMT": {}, "CUMPRINC": {}, "DATE": {},
+	"DATEDIF": {}, "DATEVALUE": {}, "DAVERAGE": {}, "DAY": {}, "DAYS": {}, "DAYS360": {}, "DB": {},
+	"DBCS": {}, "DCOUNT": {}, "DCOUNTA": {}, "DDB": {}, "DEC2BIN": {}, "DEC2HEX": {}, "DEC2OCT": {},
+	"DECIMAL": {}, "DEGREES": {}, "DELTA": {}, "DEVSQ": {}, "DGET": {}, "DISC": {}, "DMAX": {},
+	"DMIN": {}, "DOLLAR": {}, "DOLLARDE": {}, "DOLLARFR": {}, "DPRODUCT": {}, "DROP": {},
+	"DSTDEV": {}, "DSTDEVP": {}, "DSUM": {}, "DURATION": {}, "DVAR": {}, "DVARP": {}, "EDATE": {},
+	"EFFECT": {}, "ENCODEURL": {}, "EOMONTH": {}, "ERF": {}, "ERF.PRECISE": {}, "ERFC": {},
+	"ERFC.PRECISE": {}, "ERROR.TYPE": {}, "EUROCONVERT": {}, "EVEN": {}, "EXACT": {}, "EXP": {},
+	"EXPAND": {}, "EXPON.DIST": {}, "EXPONDIST": {}, "F.DIST": {}, "F.DIST.RT": {}, "F.INV": {},
+	"F.INV.RT": {}, "F.TEST": {}, "FACT": {}, "FACTDOUBLE": {}, "FALSE": {}, "FDIST": {},
+	"FILTER": {}, "FILTERXML": {}, "FIND": {}, "FINDB": {}, "FINV": {}, "FISHER": {}, "FISHERINV": {},
+	"FIXED": {}, "FLOOR": {}, "FLOOR.MATH": {}, "FLOOR.PRECISE": {}, "FORECAST": {},
+	"FORECAST.ETS": {}, "FORECAST.ETS.CONFINT": {}, "FORECAST.ETS.SEASONALITY": {},
+	"FORECAST.ETS.STAT": {}, "FORECAST.LINEAR": {}, "FORMULATEXT": {}, "FREQUENCY": {}, "FTEST": {},
+	"FV": {}, "FVSCHEDULE": {}, "GAMMA": {}, "GAMMA.DIST": {}, "GAMMA.INV": {}, "GAMMADIST": {},
+	"GAMMAINV": {}, "GAMMALN": {}, "GAMMALN.PRECISE": {}, "GAUSS": {}, "GCD": {}, "GEOMEAN": {},
+	"GESTEP": {}, "GETPIVOTDATA": {}, "GROWTH": {}, "HARMEAN": {}, "HEX2BIN": {}, "HEX2DEC": {},
+	"HEX2OCT": {}, "HLOOKUP": {}, "HOUR": {}, "HSTACK": {}, "HYPERLINK": {}, "HYPGEOM.DIST": {},
+	"HYPGEOMDIST": {}, "IF": {}, "IFERROR": {}, "IFNA": {}, "IFS": {}, "IMABS": {}, "IMAGE": {},
+	"IMAGINARY": {}, "IMARGUMENT": {}, "IMCONJUGATE": {}, "IMCOS": {}, "IMCOSH": {}, "IMCOT": {},
+	"IMCSC": {}, "IMCSCH": {}, "IMDIV": {}, "IMEXP": {}, "IMLN": {}, "IMLOG10": {}, "IMLOG2": {},
+	"IMPOWER": {}, "IMPRODUCT": {}, "IMREAL": {}, "IMSEC": {}, "IMSECH": {}, "IMSIN": {},
+	"IMSINH": {}, "IMSQRT": {}, "IMSUB": {}, "IMSUM": {}, "IMTAN": {}, "INDEX": {}, "INDIRECT": {},
+	"INFO": {}, "INT": {}, "INTERCEPT": {}, "INTRATE": {}, "IPMT": {}, "IRR": {}, "ISBLANK": {},
+	"ISERR": {}, "ISERROR": {}, "ISEVEN": {}, "ISFORMULA": {}, "ISLOGICAL": {}, "ISNA": {},
+	"ISNONTEXT": {}, "ISNUMBER": {}, "ISO.CEILING": {}, "ISODD": {}, "ISOMITTED": {},
+	"ISOWEEKNUM": {}, "ISPMT": {}, "ISREF": {}, "ISTEXT": {}, "JIS": {}, "KURT": {}, "LAMBDA": {},
+	"LARGE": {}, "LCM": {}, "LEFT": {}, "LEFTB": {}, "LEN": {}, "LENB": {}, "LET": {}, "LINEST": {},
+	"LN": {}, "LOG": {}, "LOG10": {}, "LOGEST": {}, "LOGINV": {}, "LOGNORM.DIST": {},
+	"LOGNORM.INV": {}, "LOGNORMDIST": {}, "LOOKUP": {}, "LOWER": {}, "MAKEARRAY": {}, "MAP": {},
+	"MATCH": {}, "MAX": {}, "MAXA": {}, "MAXIFS": {}, "MDETERM": {}, "MDURATION": {}, "MEDIAN": {},
+	"MID": {}, "MIDB": {}, "MIN": {}, "MINA": {}, "MINIFS": {}, "MINUTE": {}, "MINVERSE": {},
+	"MIRR": {}, "MMULT": {}, "MOD": {}, "MODE": {}, "MODE.MULT": {}, "MODE.SNGL": {}, "MONTH": {},
+	"MROUND": {}, "MULTINOMIAL": {}, "MUNIT": {}, "N": {}, "NA": {}, "NEGBINOM.DIST": {},
+	"NEGBINOMDIST": {}, "NETWORKDAYS": {}, "NETWORKDAYS.INTL": {}, "NOMINAL": {}, "NORM.DIST": {},
+	"NORM.INV": {}, "NORM.S.DIST": {}, "NORM.S.INV": {}, "NORMDIST": {}, "NORMINV": {},
+	"NORMSDIST": {}, "NORMSINV": {}, "NOT": {}, "NOW": {}, "NPER": {}, "NPV": {}, "NUMBERVALUE": {},
+	"OCT2BIN": {}, "OCT2DEC": {}, "OCT2HEX": {}, "ODD": {}, "ODDFPRICE": {}, "ODDFYIELD": {},
+	"ODDLPRICE": {}, "ODDLYIELD": {}, "OFFSET": {}, "OR": {}, "PDURATION": {}, "PEARSON": {},
+	"PERCENTILE": {}, "PERCENTILE.EXC": {}, "PERCENTILE.INC": {}, "PERCENTRANK": {},
+	"PERCENTRANK.EXC": {}, "PERCENTRANK.INC": {}, "PERMUT": {}, "PERMUTATIONA": {}, "PHI": {},
+	"PHONETIC": {}, "PI": {}, "PMT": {}, "POISSON": {}, "POISSON.DIST": {}, "POWER": {}, "PPMT": {},
+	"PRICE": {}, "PRICEDISC": {}, "PRICEMAT": {}, "PROB": {}, "PRODUCT": {}, "PROPER": {}, "PV": {},
+	"QUARTILE": {}, "QUARTILE.EXC": {}, "QUARTILE.INC": {}, "QUOTIENT": {}, "RADIANS": {}, "RAND": {},
+	"RANDARRAY": {}, "RANDBETWEEN": {}, "RANK": {}, "RANK.AVG": {}, "RANK.EQ": {}, "RATE": {},
+	"RECEIVED": {}, "REDUCE": {}, "REGISTER.ID": {}, "REPLACE": {}, "REPLACEB": {}, "REPT": {},
+	"RIGHT": {}, "RIGHTB": {}, "ROMAN": {}, "ROUND": {}, "ROUNDDOWN": {}, "ROUNDUP": {}, "ROW": {},
+	"ROWS": {}, "RRI": {}, "RSQ": {}, "RTD": {}, "SCAN": {}, "SEARCH": {}, "SEARCHB": {}, "SEC": {},
+	"SECH": {}, "SECOND": {}, "SEQUENCE": {}, "SERIESSUM": {}, "SHEET": {}, "SHEETS": {}, "SIGN": {},
+	"SIN": {}, "SINH": {}, "SKEW": {}, "SKEW.P": {}, "SLN": {}, "SLOPE": {}, "SMALL": {}, "SORT": {},
+	"SORTBY": {}, "SQRT": {}, "SQRTPI": {}, "STANDARDIZE": {}, "STDEV": {}, "STDEV.P": {},
+	"STDEV.S": {}, "STDEVA": {}, "STDEVP": {}, "STDEVPA": {}, "STEYX": {}, "SUBSTITUTE": {},
+	"SUBTOTAL": {}, "SUM": {}, "SUMIF": {}, "SUMIFS": {}, "SUMPRODUCT": {}, "SUMSQ": {},
+	"SUMX2MY2": {}, "SUMX2PY2": {}, "SUMXMY2": {}, "SWITCH": {}, "SYD": {}, "T": {}, "T.DIST": {},
+	"T.DIST.2T": {}, "T.DIST.RT": {}, "T.INV": {}, "T.INV.2T": {}, "T.TEST": {}, "TAKE": {},
+	"TAN": {}, "TANH": {}, "TBILLEQ": {}, "TBILLPRICE": {}, "TBILLYIELD": {}, "TDIST": {}, "TEXT": {},
+	"TEXTAFTER": {}, "TEXTBEFORE": {}, "TEXTJOIN": {}, "TEXTSPLIT": {}, "TIME": {}, "TIMEVALUE": {},
+	"TINV": {}, "TOCOL": {}, "TODAY": {}, "TOROW": {}, "TRANSPOSE": {}, "TREND": {}, "TRIM": {},
+	"TRIMMEAN": {}, "TRUE": {}, "TRUNC": {}, "TTEST": {}, "TYPE": {}, "UNICHAR": {}, "UNICODE": {},
+	"UNIQUE": {}, "UPPER": {}, "VALUE": {}, "VALUETOTEXT": {}, "VAR": {}, "VAR.P": {}, "VAR.S": {},
+	"VARA": {}, "VARP": {}, "VARPA": {}, "VDB": {}, "VLOOKUP": {}, "VSTACK": {}, "WEBSERVICE": {},
+	"WEEKDAY": {}, "WEEKNUM": {}, "WEIBULL": {}, "WEIBULL.DIST": {}, "WORKDAY": {},
+	"WORKDAY.INTL": {}, "WRAPCOLS": {}, "WRAPROWS": {}, "XIRR": {}, "XLOOKUP": {}, "XMATCH": {},
+	"XNPV": {}, "XOR": {}, "YEAR": {}, "YEARFRAC": {}, "YIELD": {}, "YIELDDISC": {}, "YIELDMAT": {},
+	"Z.TEST": {}, "ZTEST": {},
+}
+
+// VolatileFunctions lists the upper case names of the functions which are
+// recalculated on every change to the workbook, used by SafeToInline.
+// 易失性函数
+var VolatileFunctions = map[string]struct{}{
+	"NOW": {}, "TODAY": {}, "RAND": {}, "RANDBETWEEN": {}, "RANDARRAY": {},
+	"OFFSET": {}, "INDIRECT": {}, "CELL": {}, "INFO": {},
+}
+
 // Token encapsulate a formula token.
 //公式标记
 type Token struct {
 	TValue   string //标记的值
 	TType    string //标记的类型
 	TSubType string //标记的子类型
+	Start    int    //标记在公式中的起始位置(字符偏移量)
+	End      int    //标记在公式中的结束位置(不含)
+	Line     int    //多行模式下标记起始位置所在的行,从1开始
+	Column   int    //多行模式下标记起始位置所在的列,从1开始
+}
+
+// ExportToken is a formula token for export to other systems, holding only
+// the value, type and subtype of a Token.
+// 用于导出的公式标记
+type ExportToken struct {
+	Value   string //标记的值
+	Type    string //标记的类型
+	SubType string //标记的子类型
 }
 
 // Tokens directly maps the ordered list of tokens.
@@ -93,6 +283,196 @@ type Parser struct {
 	InPath     bool
 	InRange    bool
 	InError    bool
+
+	// StrictEquals makes ParseChecked reject formulas which don't begin with
+	// "=" instead of prepending it.
+	StrictEquals bool
+	// DetectNames makes Parse subtype operands which are defined names
+	// rather than cell references as Name instead of Range.
+	DetectNames bool
+	// SpillAsOperator makes Parse emit the spilled range operator "#" after
+	// a cell reference as a separate postfix operator token, instead of
+	// keeping it in the operand such as "A1#".
+	SpillAsOperator bool
+	// RefineRanges makes Parse subtype single cell references as Cell, whole
+	// column references as Column and whole row references as Row, leaving
+	// Range for cell ranges and any other reference.
+	RefineRanges bool
+	// CommentPrefix, when not empty, makes Parse ignore everything from the
+	// first occurrence of the prefix outside strings, quoted sheet names and
+	// brackets, for dialects which append comments such as "// note".
+	CommentPrefix string
+	// SplitRanges makes Parse split references such as A1:B2 into their
+	// endpoints and an infix ":" operator, subtyped Range between cells and
+	// 3DRange between the sheets of a reference such as Sheet1:Sheet3!A1.
+	// A ":" after a function call or subexpression, such as between the
+	// calls of =INDEX(A:A,1):INDEX(A:A,5), is also emitted as a Range operator.
+	SplitRanges bool
+	// TextEntryAware makes Parse treat content starting with "'", which
+	// Excel stores as forced text, as a single text operand holding the rest
+	// of the content, instead of the start of a quoted sheet name.
+	TextEntryAware bool
+	// R1C1 makes RefineRanges read references in R1C1 style, so R1 is the
+	// whole row 1 and C1 the whole column 1 instead of the cells in columns R
+	// and C, and R1C1 is a cell.
+	R1C1 bool
+	// KeepNoops makes Parse keep the unary plus signs, such as the second "+"
+	// in =1++2, as Noop tokens instead of dropping them.
+	KeepNoops bool
+	// MultiLine makes Parse treat line breaks and tabs as whitespace, as in
+	// formulas formatted over several lines, and set the Line and Column of
+	// each token, both counted from 1, from its Start. Windows line endings
+	// "\r\n" are replaced with "\n" first, so they don't shift offsets.
+	MultiLine bool
+	// KnownFunctions, when not nil, holds the upper case names of the
+	// functions known to the caller. With DetectNames, operands matching one
+	// of them are subtyped Range rather than Name, as they are more likely a
+	// function missing its parentheses than a defined name, and Lint reports
+	// them. Parse also reads one of them followed by whitespace and "(",
+	// like those of BuiltinFunctions, as a function call rather than an
+	// intersection.
+	KnownFunctions map[string]struct{}
+	// Dialect is the spreadsheet application the formula is written for.
+	// Both write formulas with the separators of LocaleUS; Google Sheets
+	// also accepts open ended ranges such as A2:A, which Lint reports as
+	// invalid references in Excel formulas.
+	Dialect Dialect
+	// EmitEOF makes Parse append a final token of type TokenTypeEOF, with an
+	// empty value, at the end of the formula.
+	EmitEOF bool
+	// Locale holds the separators Parse expects in array constants, such as
+	// LocaleDE for ={1,5;2,5}, whose rows hold 1.5 and 2.5. The zero value is
+	// LocaleUS. Numbers always keep "." as their decimal separator.
+	Locale Locale
+	// NormalizeReferences makes Parse write the column letters of A1 style
+	// references in upper case, as Excel does, leaving sheet names alone.
+	NormalizeReferences bool
+	// UnquoteSheetNames makes Parse drop the single quotes around sheet
+	// names and collapse the doubled quotes inside them, such as My Sheet!A1
+	// for 'My Sheet'!A1 and It's!A1 for 'It''s'!A1, as Parse did before it
+	// kept the quotes. The operand values are then no longer valid formula
+	// text, so Render doesn't round-trip them.
+	UnquoteSheetNames bool
+
+	runes             []rune //公式的字符数组,解析时预先转换
+	tokenStart        int
+	functionRewriter  func(name string) string
+	operandClassifier func(value string) string
+}
+
+// LintIssue describes a potential problem found in a parsed formula.
+// 解析好的公式中可能存在的问题
+type LintIssue struct {
+	Token   Token  //问题所在的标记
+	Message string //问题的描述
+}
+
+// SyntaxError describes a problem which makes a formula invalid, at a rune
+// offset of the parsed formula, which always starts with "=".
+// 公式中的语法错误
+type SyntaxError struct {
+	Offset  int    //错误在公式中的位置(字符偏移量)
+	Message string //错误的描述
+}
+
+// Error provides function to get the description of the syntax error with
+// its offset.
+// 返回语法错误的描述
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+// Comparison is a comparison in a formula: a logical operator with the
+// tokens of its operands.
+// 公式中的比较运算
+type Comparison struct {
+	Left  []Token //左侧操作数的标记
+	Op    Token   //比较操作符
+	Right []Token //右侧操作数的标记
+}
+
+// DynamicReferenceCall is an INDIRECT or OFFSET call in a formula: the Start
+// token of the function with the tokens of each of its arguments.
+// 公式中的INDIRECT或OFFSET函数调用
+type DynamicReferenceCall struct {
+	Function Token     //函数的开始标记
+	Args     [][]Token //各个参数的标记
+}
+
+// FormulaMetrics summarizes the content of a parsed formula.
+// 公式的统计指标
+type FormulaMetrics struct {
+	References int //引用和名称的个数
+	Functions  int //函数调用的个数
+	Operators  int //操作符的个数
+	Literals   int //常量的个数
+	Depth      int //函数、子表达式和数组的最大嵌套深度
+}
+
+// Node is a node of the abstract syntax tree of a formula. Operands are
+// leaves; operators have their operands as children, and function calls,
+// including the ARRAY and ARRAYROW of array constants, have their arguments
+// as children, with the function's Start token as Token.
+// 公式抽象语法树的节点
+type Node struct {
+	Token    Token   //节点的标记
+	Children []*Node //子节点
+}
+
+// Locale describes the separators used by a regional variant of Excel when
+// writing formulas.
+// 区域设置,描述公式中使用的分隔符
+type Locale struct {
+	ArgumentSeparator    string //参数分隔符
+	ArrayColumnSeparator string //数组列分隔符
+	ArrayRowSeparator    string //数组行分隔符
+	DecimalSeparator     string //小数点
+}
+
+var (
+	// LocaleUS is the locale used by the English (United States) Excel.
+	// 美国英语区域设置
+	LocaleUS = Locale{
+		ArgumentSeparator:    ",",
+		ArrayColumnSeparator: ",",
+		ArrayRowSeparator:    ";",
+		DecimalSeparator:     ".",
+	}
+	// LocaleDE is the locale used by the German Excel.
+	// 德语区域设置
+	LocaleDE = Locale{
+		ArgumentSeparator:    ";",
+		ArrayColumnSeparator: "\\",
+		ArrayRowSeparator:    ";",
+		DecimalSeparator:     ",",
+	}
+)
+
+// Dialect identifies the spreadsheet application a formula is written for.
+// 公式所属的电子表格应用
+type Dialect int
+
+// Dialects of the formulas understood by the parser.
+const (
+	DialectExcel        Dialect = iota //Microsoft Excel
+	DialectGoogleSheets                //Google Sheets
+)
+
+// String provides function to get the token in the form value<Type/SubType>.
+// 以 值<类型/子类型> 的格式返回标记
+func (t Token) String() string {
+	return t.TValue + "<" + t.TType + "/" + t.TSubType + ">"
+}
+
+// String provides function to get the tokens of the list separated by
+// spaces.
+// 返回以空格分隔的全部标记
+func (tk Tokens) String() string {
+	items := make([]string, len(tk.Items))
+	for i, t := range tk.Items {
+		items[i] = t.String()
+	}
+	return strings.Join(items, " ")
 }
 
 // fToken provides function to encapsulate a formula token.
@@ -127,6 +507,22 @@ func (tk *Tokens) addRef(token Token) {
 	tk.Items = append(tk.Items, token)
 }
 
+// add provides function to add a token spanning the rune offsets [start, end)
+// of the formula to the end of the token list.
+// 往标记堆栈末尾添加一个带有位置信息的新标记
+func (ps *Parser) add(value, tokenType, subType string, start, end int) Token {
+	return ps.addRef(fToken(value, tokenType, subType), start, end)
+}
+
+// addRef provides function to add a token spanning the rune offsets
+// [start, end) of the formula to the end of the token list.
+// 往标记堆栈末尾添加一个带有位置信息的标记
+func (ps *Parser) addRef(token Token, start, end int) Token {
+	token.Start, token.End = start, end
+	ps.Tokens.addRef(token)
+	return token
+}
+
 // reset provides function to reset the index to -1.
 // 重置标记堆栈的索引为-1
 func (tk *Tokens) reset() {
@@ -247,19 +643,115 @@ func ExcelParser() Parser {
 	return Parser{}
 }
 
+// ParserForDialect provides function to get a parser for the formulas of the
+// given spreadsheet application.
+// 构建指定电子表格应用的公式解析器
+func ParserForDialect(d Dialect) Parser {
+	return Parser{Dialect: d}
+}
+
+// ParseMultiple provides function to parse text holding one formula per
+// line, such as formulas joined by newlines, into the tokens of each formula
+// in order. Newlines inside text literals, quoted sheet names or brackets
+// don't end a formula, a "\r" before a newline is dropped, and blank lines
+// are skipped.
+// 解析按行分隔的多个公式
+func ParseMultiple(text string) [][]Token {
+	var formulas []string
+	var line strings.Builder
+	inString, inPath, brackets := false, false, 0
+	flush := func() {
+		if formula := strings.TrimSuffix(line.String(), "\r"); strings.TrimSpace(formula) != "" {
+			formulas = append(formulas, formula)
+		}
+		line.Reset()
+	}
+	for _, r := range text {
+		switch {
+		case r == '"' && !inPath && brackets == 0:
+			inString = !inString
+		case r == '\'' && !inString && brackets == 0:
+			inPath = !inPath
+		case r == '[' && !inString && !inPath:
+			brackets++
+		case r == ']' && !inString && !inPath && brackets > 0:
+			brackets--
+		case r == '\n' && !inString && !inPath && brackets == 0:
+			flush()
+			continue
+		}
+		line.WriteRune(r)
+	}
+	flush()
+	result := make([][]Token, len(formulas))
+	for i, formula := range formulas {
+		p := ExcelParser()
+		result[i] = p.Parse(formula)
+	}
+	return result
+}
+
+// breakArrayRow provides a method to end the current row of an array
+// constant at the row separator and start the next one.
+// 在行分隔符处结束数组常量的当前行并开始下一行
+func (ps *Parser) breakArrayRow() {
+	if len(ps.Token) > 0 {
+		ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset) //结束现有操作符标记,但不设置子标记类型
+		ps.Token = ""
+	}
+	ps.addRef(ps.TokenStack.pop(), ps.Offset, ps.Offset) //子标记结束标记
+	ps.add(",", TokenTypeArgument, "", ps.Offset, ps.Offset+1)
+	//下一个子标记开始
+	ps.TokenStack.push(ps.add("ARRAYROW", TokenTypeFunction, TokenSubTypeStart, ps.Offset+1, ps.Offset+1))
+	ps.Offset++
+}
+
+// locale provides a method to get the locale of array constants, LocaleUS
+// when none is set.
+// 返回数组常量的区域设置,未设置时为LocaleUS
+func (ps *Parser) locale() Locale {
+	if ps.Locale == (Locale{}) {
+		return LocaleUS
+	}
+	return ps.Locale
+}
+
 // getTokens return a token stream (list).
 // 从公式字符串中获取标记堆栈
 func (ps *Parser) getTokens(formula string) Tokens {
+	if ps.CommentPrefix != "" {
+		ps.Formula = stripComment(ps.Formula, ps.CommentPrefix) //去掉注释
+	}
+	if ps.MultiLine {
+		ps.Formula = strings.Replace(ps.Formula, "\r\n", "\n", -1) //统一换行符,使标记位置与换行符无关
+	}
 	ps.Formula = strings.TrimSpace(ps.Formula) //剔除公式中所有的空格
 	f := []rune(ps.Formula)
+	// forced text entry, the rest of the content is a literal
+	// 以单引号开头的强制文本输入,其余内容均为文本
+	if ps.TextEntryAware && len(f) > 0 && f[0] == '\'' {
+		ps.Formula = "=" + ps.Formula
+		ps.runes = []rune(ps.Formula)
+		tokens := fTokens()
+		tokens.addRef(Token{TValue: string(f[1:]), TType: TokenTypeOperand, TSubType: TokenSubTypeText, Start: 2, End: len(f) + 1})
+		if ps.EmitEOF {
+			tokens.addRef(Token{TType: TokenTypeEOF, Start: len(ps.runes), End: len(ps.runes)})
+		}
+		return tokens
+	}
 	if len(f) > 0 {
 		if string(f[0]) != "=" { //检查公式的第一个字符是否为等号
 			ps.Formula = "=" + ps.Formula //不是就加上
 		}
+		ps.Offset = 1 //跳过公式开头的等号
 	}
+	ps.runes = []rune(ps.Formula) //预先转换为字符数组,避免每次读取字符时重复转换
 
 	// state-dependent character evaluation (order is important)
 	for !ps.EOF() { //尚未到最后一个字符
+		if len(ps.Token) == 0 && !ps.InString && !ps.InPath {
+			ps.tokenStart = ps.Offset //记录下一个标记的起始位置
+		}
 
 		// double-quoted strings,双引号字符串
 		// embeds are doubled,嵌入在两个引号中
@@ -270,9 +762,9 @@ func (ps *Parser) getTokens(formula string) Tokens {
 					ps.Token += "\"" //标记字符串添加上双引号
 					ps.Offset++      //标记位置后移一位
 				} else { //下一个字符不是双引号
-					ps.InString = false                                         //字符串结束了
-					ps.Tokens.add(ps.Token, TokenTypeOperand, TokenSubTypeText) //添加一个类型为操作数,子类型为字符串的标记
-					ps.Token = ""                                               //当前标记清空
+					ps.InString = false                                                              //字符串结束了
+					ps.add(ps.Token, TokenTypeOperand, TokenSubTypeText, ps.tokenStart, ps.Offset+1) //添加一个类型为操作数,子类型为字符串的标记
+					ps.Token = ""                                                                    //当前标记清空
 				}
 			} else { //如果当前标记不是双引号
 				ps.Token += ps.currentChar() //添加当前字符到标记字符串中
@@ -283,13 +775,21 @@ func (ps *Parser) getTokens(formula string) Tokens {
 
 		// single-quoted strings (links),单引号字符串(连接)
 		// embeds are double,嵌入在两个引号中
-		// end does not mark a token
+		// end does not mark a token, quotes are kept in the token
+		// 引号保留在标记中
 		if ps.InPath { //是路径
 			if ps.currentChar() == "'" { //当前字符串是一个单引号
 				if ps.nextChar() == "'" { //下一个字符串也是一个单引号
-					ps.Token += "'" //标记字符串加上这个单引号
-					ps.Offset++     //标记位置后移一位
+					if ps.UnquoteSheetNames {
+						ps.Token += "'" //只保留一个单引号
+					} else {
+						ps.Token += "''" //标记字符串加上这两个单引号
+					}
+					ps.Offset++ //标记位置后移一位
 				} else { //下一个位置不是单引号
+					if !ps.UnquoteSheetNames {
+						ps.Token += "'"
+					}
 					ps.InPath = false
 				}
 			} else {
@@ -316,10 +816,13 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		if ps.InError { //在错误标记中
 			ps.Token += ps.currentChar()
 			ps.Offset++
-			//如果当前标记是错误标记中的一个
-			if inStrSlice([]string{",#NULL!,", ",#DIV/0!,", ",#VALUE!,", ",#REF!,", ",#NAME?,", ",#NUM!,", ",#N/A,"}, ","+ps.Token+",") != -1 {
-				ps.InError = false                                           //错误标记结束
-				ps.Tokens.add(ps.Token, TokenTypeOperand, TokenSubTypeError) //添加一个操作数错误标记
+			//如果当前标记是错误标记中的一个,错误标记均以!、?或A结尾
+			if c := ps.Token[len(ps.Token)-1]; c != '!' && c != '?' && c != 'A' {
+				continue
+			}
+			if _, ok := errorValues[ps.Token]; ok {
+				ps.InError = false                                                              //错误标记结束
+				ps.add(ps.Token, TokenTypeOperand, TokenSubTypeError, ps.tokenStart, ps.Offset) //添加一个操作数错误标记
 				ps.Token = ""
 			}
 			continue
@@ -328,8 +831,7 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		// scientific notation check//科学计数法检查
 		//当前字符为加号或者减号,并且当前标记的长度已经大于1
 		if strings.ContainsAny(ps.currentChar(), "+-") && len(ps.Token) > 1 {
-			r, _ := regexp.Compile(`^[1-9]{1}(\.[0-9]+)?E{1}$`)
-			if r.MatchString(ps.Token) { //当前标记符合科学计数法的正则
+			if scientificPattern.MatchString(ps.Token) { //当前标记符合科学计数法的正则
 				ps.Token += ps.currentChar() //添加上当前标记
 				ps.Offset++
 				continue
@@ -341,8 +843,9 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		if ps.currentChar() == "\"" { //当前字符为双引号
 			if len(ps.Token) > 0 { //如果标记长度已经大于0
 				// not expected
-				ps.Tokens.add(ps.Token, TokenTypeUnknown, "") //未知标记
-				ps.Token = ""                                 //结束当前标记
+				ps.add(ps.Token, TokenTypeUnknown, "", ps.tokenStart, ps.Offset) //未知标记
+				ps.Token = ""                                                    //结束当前标记
+				ps.tokenStart = ps.Offset
 			}
 			ps.InString = true //开始在字符串中标记
 			ps.Offset++
@@ -352,10 +855,14 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		if ps.currentChar() == "'" { //当前字符为单引号
 			if len(ps.Token) > 0 { //如果标记长度已经大于0
 				// not expected
-				ps.Tokens.add(ps.Token, TokenTypeUnknown, "") //未知标记
+				ps.add(ps.Token, TokenTypeUnknown, "", ps.tokenStart, ps.Offset) //未知标记
 				ps.Token = ""
+				ps.tokenStart = ps.Offset
 			}
 			ps.InPath = true //开启路径
+			if !ps.UnquoteSheetNames {
+				ps.Token += ps.currentChar()
+			}
 			ps.Offset++
 			continue
 		}
@@ -367,11 +874,37 @@ func (ps *Parser) getTokens(formula string) Tokens {
 			continue
 		}
 
+		// range operator between the results of function calls or
+		// subexpressions, such as INDEX(A:A,1):INDEX(A:A,5), with SplitRanges
+		// 拆分引用时,函数调用或子表达式结果之间的范围操作符
+		if ps.SplitRanges && ps.currentChar() == ":" && len(ps.Token) == 0 {
+			if n := len(ps.Tokens.Items); n > 0 && ps.Tokens.Items[n-1].TSubType == TokenSubTypeStop {
+				ps.add(ps.currentChar(), TokenTypeOperatorInfix, TokenSubTypeRange, ps.Offset, ps.Offset+1)
+				ps.Offset++
+				continue
+			}
+		}
+
 		if ps.currentChar() == "#" { //当前字符为井号
+			// spilled range operator after a cell reference, unless an error
+			// value such as the #N/A of B2#N/A follows
+			// 单元格引用后的溢出区域操作符,其后为错误值时除外
+			if isSpillAnchor(ps.Token) && !ps.errorValueAt(ps.Offset) {
+				if ps.SpillAsOperator {
+					ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
+					ps.Token = ""
+					ps.add(ps.currentChar(), TokenTypeOperatorPostfix, TokenSubTypeSpill, ps.Offset, ps.Offset+1)
+				} else {
+					ps.Token += ps.currentChar()
+				}
+				ps.Offset++
+				continue
+			}
 			if len(ps.Token) > 0 {
 				// not expected
-				ps.Tokens.add(ps.Token, TokenTypeUnknown, "")
+				ps.add(ps.Token, TokenTypeUnknown, "", ps.tokenStart, ps.Offset)
 				ps.Token = ""
+				ps.tokenStart = ps.Offset
 			}
 			ps.InError = true //开启错误标记
 			ps.Token += ps.currentChar()
@@ -383,51 +916,75 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		if ps.currentChar() == "{" { //当前字符为左大括号
 			if len(ps.Token) > 0 {
 				// not expected
-				ps.Tokens.add(ps.Token, TokenTypeUnknown, "")
+				ps.add(ps.Token, TokenTypeUnknown, "", ps.tokenStart, ps.Offset)
 				ps.Token = ""
 			}
 			//开始数组和数组的行
-			ps.TokenStack.push(ps.Tokens.add("ARRAY", TokenTypeFunction, TokenSubTypeStart))
-			ps.TokenStack.push(ps.Tokens.add("ARRAYROW", TokenTypeFunction, TokenSubTypeStart))
+			ps.TokenStack.push(ps.add("ARRAY", TokenTypeFunction, TokenSubTypeStart, ps.Offset, ps.Offset+1))
+			ps.TokenStack.push(ps.add("ARRAYROW", TokenTypeFunction, TokenSubTypeStart, ps.Offset+1, ps.Offset+1))
 			ps.Offset++
 			continue
 		}
 
-		if ps.currentChar() == ";" { //当前字符为分号
-			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "") //结束现有操作符标记,但不设置子标记类型
-				ps.Token = ""
+		// separators and decimal separator of array constants in the locale
+		// 按照区域设置处理数组常量中的分隔符和小数点
+		if loc := ps.locale(); loc != LocaleUS && ps.TokenStack.value() == "ARRAYROW" {
+			switch ps.currentChar() {
+			case loc.DecimalSeparator:
+				if _, err := strconv.ParseFloat(ps.Token, 64); err == nil && !strings.ContainsAny(ps.Token, ".Ee") {
+					ps.Token += "." //数值统一使用"."作为小数点
+					ps.Offset++
+					continue
+				}
+			case loc.ArrayColumnSeparator:
+				if len(ps.Token) > 0 {
+					ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
+					ps.Token = ""
+				}
+				ps.add(",", TokenTypeArgument, "", ps.Offset, ps.Offset+1)
+				ps.Offset++
+				continue
+			case loc.ArrayRowSeparator:
+				ps.breakArrayRow()
+				continue
 			}
-			ps.Tokens.addRef(ps.TokenStack.pop()) //子标记结束标记
-			ps.Tokens.add(",", TokenTypeArgument, "")
-			//下一个子标记开始
-			ps.TokenStack.push(ps.Tokens.add("ARRAYROW", TokenTypeFunction, TokenSubTypeStart))
-			ps.Offset++
+		}
+
+		if ps.currentChar() == ";" { //当前字符为分号
+			ps.breakArrayRow()
 			continue
 		}
 
 		if ps.currentChar() == "}" { //当前字符为右大括号
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "")
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
 				ps.Token = ""
 			}
-			ps.Tokens.addRef(ps.TokenStack.pop())
-			ps.Tokens.addRef(ps.TokenStack.pop())
+			ps.addRef(ps.TokenStack.pop(), ps.Offset, ps.Offset)
+			ps.addRef(ps.TokenStack.pop(), ps.Offset, ps.Offset+1)
 			ps.Offset++
 			continue
 		}
 
 		// trim white-space
-		if ps.currentChar() == " " { //当前标记为空格
+		if ps.isWhitespace(ps.currentChar()) { //当前标记为空格
+			// 已知函数名与左括号之间的空格,如"SUM (A1)",忽略空格
+			if len(ps.Token) > 0 && isName(ps.Token) && ps.isFunctionName(ps.Token) {
+				if paren := ps.skipWhitespace(ps.Offset); paren < len(ps.runes) && ps.runes[paren] == '(' {
+					ps.Offset = paren
+					continue
+				}
+			}
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "") //结束一个标记
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset) //结束一个标记
 				ps.Token = ""
 			}
-			ps.Tokens.add("", TokenTypeWhitespace, "") //添加一个空格标记
+			start := ps.Offset
 			ps.Offset++
-			for (ps.currentChar() == " ") && (!ps.EOF()) { //过滤掉多余的空格
+			for ps.isWhitespace(ps.currentChar()) && (!ps.EOF()) { //过滤掉多余的空格
 				ps.Offset++
 			}
+			ps.add("", TokenTypeWhitespace, "", start, ps.Offset) //添加一个空格标记
 			continue
 		}
 
@@ -435,10 +992,10 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		//如果紧后的两个字符为比价操作符
 		if inStrSlice([]string{",>=,", ",<=,", ",<>,"}, ","+ps.doubleChar()+",") != -1 {
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "") //结束当前操作数
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset) //结束当前操作数
 				ps.Token = ""
 			}
-			ps.Tokens.add(ps.doubleChar(), TokenTypeOperatorInfix, TokenSubTypeLogical) //添加为比较操作符
+			ps.add(ps.doubleChar(), TokenTypeOperatorInfix, TokenSubTypeLogical, ps.Offset, ps.Offset+2) //添加为比较操作符
 			ps.Offset += 2
 			continue
 		}
@@ -447,10 +1004,10 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		//如果当前字符为运算符
 		if strings.ContainsAny("+-*/^&=><", ps.currentChar()) {
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "")
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
 				ps.Token = ""
 			}
-			ps.Tokens.add(ps.currentChar(), TokenTypeOperatorInfix, "") //中缀操作符
+			ps.add(ps.currentChar(), TokenTypeOperatorInfix, "", ps.Offset, ps.Offset+1) //中缀操作符
 			ps.Offset++
 			continue
 		}
@@ -459,10 +1016,10 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		//后缀操作符
 		if ps.currentChar() == "%" {
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "")
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
 				ps.Token = ""
 			}
-			ps.Tokens.add(ps.currentChar(), TokenTypeOperatorPostfix, "")
+			ps.add(ps.currentChar(), TokenTypeOperatorPostfix, "", ps.Offset, ps.Offset+1)
 			ps.Offset++
 			continue
 		}
@@ -471,10 +1028,10 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		// 子表达式
 		if ps.currentChar() == "(" {
 			if len(ps.Token) > 0 {
-				ps.TokenStack.push(ps.Tokens.add(ps.Token, TokenTypeFunction, TokenSubTypeStart))
+				ps.TokenStack.push(ps.add(ps.Token, TokenTypeFunction, TokenSubTypeStart, ps.tokenStart, ps.Offset+1))
 				ps.Token = ""
 			} else {
-				ps.TokenStack.push(ps.Tokens.add("", TokenTypeSubexpression, TokenSubTypeStart))
+				ps.TokenStack.push(ps.add("", TokenTypeSubexpression, TokenSubTypeStart, ps.Offset, ps.Offset+1))
 			}
 			ps.Offset++
 			continue
@@ -484,13 +1041,18 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		// 函数、子表达式、数组的参数
 		if ps.currentChar() == "," {
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "") //逗号前的是操作数
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset) //逗号前的是操作数
 				ps.Token = ""
 			}
-			if ps.TokenStack.tp() != TokenTypeFunction {
-				ps.Tokens.add(ps.currentChar(), TokenTypeOperatorInfix, TokenSubTypeUnion)
-			} else {
-				ps.Tokens.add(ps.currentChar(), TokenTypeArgument, "")
+			// the nearest enclosing group is the top of the token stack: a
+			// function call (or array) makes the comma an argument separator,
+			// a subexpression or the top level makes it the union operator
+			// 由最近的外层分组决定:函数(或数组)中为参数分隔符,子表达式或顶层中为联合操作符
+			switch ps.TokenStack.tp() {
+			case TokenTypeFunction:
+				ps.add(ps.currentChar(), TokenTypeArgument, "", ps.Offset, ps.Offset+1)
+			default:
+				ps.add(ps.currentChar(), TokenTypeOperatorInfix, TokenSubTypeUnion, ps.Offset, ps.Offset+1)
 			}
 			ps.Offset++
 			continue
@@ -500,10 +1062,10 @@ func (ps *Parser) getTokens(formula string) Tokens {
 		// 当前字符是右括号
 		if ps.currentChar() == ")" {
 			if len(ps.Token) > 0 {
-				ps.Tokens.add(ps.Token, TokenTypeOperand, "")
+				ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
 				ps.Token = ""
 			}
-			ps.Tokens.addRef(ps.TokenStack.pop())
+			ps.addRef(ps.TokenStack.pop(), ps.Offset, ps.Offset+1)
 			ps.Offset++
 			continue
 		}
@@ -516,35 +1078,34 @@ func (ps *Parser) getTokens(formula string) Tokens {
 	// dump remaining accumulation
 	// 把剩余标记作为操作数
 	if len(ps.Token) > 0 {
-		ps.Tokens.add(ps.Token, TokenTypeOperand, "")
+		ps.add(ps.Token, TokenTypeOperand, "", ps.tokenStart, ps.Offset)
 	}
 
 	// move all tokens to a new collection, excluding all unnecessary white-space tokens
 	tokens2 := fTokens()
+	ps.Tokens.reset()
 
 	for ps.Tokens.moveNext() {
 		token := ps.Tokens.current()
 
 		// 先判断当前标记是否为空格,如果是空格
 		// 在判断是否处于堆栈的开始或者结束位置,
-		// 如果不是,再判断前一个标记是否 标记类型为函数,且子类型为结束符 或者 类型为子表达式且子类型为结束符 或者 类型为操作数
+		// 如果不是,再判断前一个标记是否 标记类型为函数,且子类型为结束符 或者 类型为子表达式且子类型为结束符 或者 类型为操作数 或者 溢出区域操作符
 		// 如果是,再判断后一个标记是否 标记类型为函数,且子类型为结束符 或者 类型为子表达式且子类型为结束符 或者 类型为操作数
 		// 如果是,添加一个操作符中缀
 		if token.TType == TokenTypeWhitespace { //如果标记的类型为空格
 			if ps.Tokens.BOF() || ps.Tokens.EOF() { //如果是标记的开始和结束,什么也不做
-			} else if !(((ps.Tokens.previous().TType == TokenTypeFunction) && (ps.Tokens.previous().TSubType == TokenSubTypeStop)) || ((ps.Tokens.previous().TType == TokenTypeSubexpression) && (ps.Tokens.previous().TSubType == TokenSubTypeStop)) || (ps.Tokens.previous().TType == TokenTypeOperand)) { //
+			} else if !(((ps.Tokens.previous().TType == TokenTypeFunction) && (ps.Tokens.previous().TSubType == TokenSubTypeStop)) || ((ps.Tokens.previous().TType == TokenTypeSubexpression) && (ps.Tokens.previous().TSubType == TokenSubTypeStop)) || (ps.Tokens.previous().TType == TokenTypeOperand) || (ps.Tokens.previous().TSubType == TokenSubTypeSpill)) { //
 			} else if !(((ps.Tokens.next().TType == TokenTypeFunction) && (ps.Tokens.next().TSubType == TokenSubTypeStart)) || ((ps.Tokens.next().TType == TokenTypeSubexpression) && (ps.Tokens.next().TSubType == TokenSubTypeStart)) || (ps.Tokens.next().TType == TokenTypeOperand)) {
 			} else {
-				tokens2.add(token.TValue, TokenTypeOperatorInfix, TokenSubTypeIntersection)
+				intersection := *token
+				intersection.TType, intersection.TSubType = TokenTypeOperatorInfix, TokenSubTypeIntersection
+				tokens2.addRef(intersection)
 			}
 			continue
 		}
 
-		tokens2.addRef(Token{
-			TValue:   token.TValue,
-			TType:    token.TType,
-			TSubType: token.TSubType,
-		})
+		tokens2.addRef(*token)
 	}
 
 	// switch infix "-" operator to prefix when appropriate, switch infix "+"
@@ -591,23 +1152,45 @@ func (ps *Parser) getTokens(formula string) Tokens {
 
 		// 如果类型为操作数,且子类型的长度为0
 		if (token.TType == TokenTypeOperand) && (len(token.TSubType) == 0) {
+			// 优先使用调用方设置的操作数分类回调
+			if ps.operandClassifier != nil {
+				if token.TSubType = ps.operandClassifier(token.TValue); token.TSubType != "" {
+					continue
+				}
+			}
 			// 如果值不可转变为数值
 			if _, err := strconv.ParseFloat(token.TValue, 64); err != nil {
 				if (token.TValue == "TRUE") || (token.TValue == "FALSE") { // 再判断是是否为TRUE或者FALSE
 					token.TSubType = TokenSubTypeLogical //如果是,则子类型为逻辑操作数
+				} else if ps.DetectNames && isName(token.TValue) && !ps.isKnownFunction(token.TValue) { // 是否为定义的名称
+					token.TSubType = TokenSubTypeName //子类型为名称
+				} else if ps.RefineRanges {
+					token.TSubType = ps.refineRange(token.TValue) //细分引用的子类型
 				} else {
 					token.TSubType = TokenSubTypeRange //子类型为范围
 				}
+				if ps.NormalizeReferences && isReferenceToken(*token) {
+					token.TValue = normalizeReference(token.TValue) //列名转换为大写
+				}
 			} else {
 				token.TSubType = TokenSubTypeNumber //子类型为数值
 			}
 			continue
 		}
 
-		// 为函数时,去掉函数前面的@字符
+		// 为函数时,去掉函数前面的@字符,操作数(如表格引用Table1[@Col])中的@保持不变
 		if token.TType == TokenTypeFunction {
-			if (len(token.TValue) > 0) && token.TValue[0:1] == "@" {
-				token.TValue = token.TValue[1:]
+			token.TValue = strings.TrimLeft(token.TValue, "@")
+			// 去掉函数名的前缀
+			for _, prefix := range FunctionPrefixes {
+				if prefix != "" && strings.HasPrefix(token.TValue, prefix) {
+					token.TValue = token.TValue[len(prefix):]
+					break
+				}
+			}
+			// 调用函数名改写回调,数组标记不参与改写
+			if ps.functionRewriter != nil && token.TSubType == TokenSubTypeStart && !isArrayToken(*token) {
+				token.TValue = ps.functionRewriter(token.TValue)
 			}
 			continue
 		}
@@ -618,27 +1201,135 @@ func (ps *Parser) getTokens(formula string) Tokens {
 	// move all tokens to a new collection, excluding all noops
 	tokens := fTokens()
 	for tokens2.moveNext() {
-		if tokens2.current().TType != TokenTypeNoop { // 保存非空的标记
-			tokens.addRef(Token{
-				TValue:   tokens2.current().TValue,
-				TType:    tokens2.current().TType,
-				TSubType: tokens2.current().TSubType,
-			})
+		if ps.KeepNoops || tokens2.current().TType != TokenTypeNoop { // 保存非空的标记
+			tokens.addRef(*tokens2.current())
 		}
 	}
 
+	markParameterNames(tokens.Items)
+	if ps.SplitRanges {
+		tokens.Items = ps.splitRanges(tokens.Items)
+	}
+	if ps.EmitEOF {
+		tokens.addRef(Token{TType: TokenTypeEOF, Start: len(ps.runes), End: len(ps.runes)})
+	}
+	if ps.MultiLine {
+		setLineColumns(ps.Formula, tokens.Items)
+	}
+
 	tokens.reset()
 	return tokens
 }
 
+// splitRanges provides a method to split each reference operand at the
+// colons outside quoted sheet names and brackets into its endpoints and
+// infix ":" operators. A colon before the "!" of the reference separates
+// sheets and is subtyped 3DRange; any other is subtyped Range.
+// 将引用操作数按冒号拆分为端点和范围操作符
+func (ps *Parser) splitRanges(tokens []Token) []Token {
+	var result []Token
+	for _, t := range tokens {
+		if !isReferenceToken(t) {
+			result = append(result, t)
+			continue
+		}
+		value := []rune(t.TValue)
+		bang := strings.LastIndex(t.TValue, "!")
+		if bang != -1 {
+			bang = len([]rune(t.TValue[:bang]))
+		}
+		from, quoted, brackets := 0, false, 0
+		emit := func(to int) {
+			piece := t
+			piece.TValue, piece.Start, piece.End = string(value[from:to]), t.Start+from, t.Start+to
+			if ps.RefineRanges {
+				piece.TSubType = ps.refineRange(piece.TValue)
+			}
+			result = append(result, piece)
+		}
+		for i, c := range value {
+			switch {
+			case c == '\'':
+				quoted = !quoted
+			case quoted:
+			case c == '[':
+				brackets++
+			case c == ']':
+				brackets--
+			case c == ':' && brackets == 0 && i > from && i < len(value)-1:
+				emit(i)
+				subType := TokenSubTypeRange
+				if i < bang {
+					subType = TokenSubType3DRange
+				}
+				result = append(result, Token{TValue: ":", TType: TokenTypeOperatorInfix, TSubType: subType, Start: t.Start + i, End: t.Start + i + 1})
+				from = i + 1
+			}
+		}
+		emit(len(value))
+	}
+	return result
+}
+
+// markParameterNames provides a method to subtype the parameter names
+// declared by LAMBDA and LET calls as Name. LAMBDA declares every argument
+// except the last, and LET declares every other argument starting with the
+// first, except the last.
+// 将LAMBDA和LET函数声明的参数名的子类型设置为名称
+func markParameterNames(tokens []Token) {
+	for i, t := range tokens {
+		if t.TType != TokenTypeFunction || t.TSubType != TokenSubTypeStart {
+			continue
+		}
+		name := strings.ToUpper(t.TValue)
+		if name != "LAMBDA" && name != "LET" {
+			continue
+		}
+		args := functionArguments(tokens, i)
+		for n, arg := range args {
+			if n == len(args)-1 || (name == "LET" && n%2 == 1) {
+				continue
+			}
+			if len(arg) == 1 && isReferenceToken(arg[0]) {
+				arg[0].TSubType = TokenSubTypeName
+			}
+		}
+	}
+}
+
+// functionArguments provides a method to split the arguments of the function
+// call opened by the Start token at startIndex into token slices sharing the
+// backing array of tokens. A call without arguments returns nil.
+// 返回函数调用的各个参数的标记切片
+func functionArguments(tokens []Token, startIndex int) [][]Token {
+	stop := MatchingStop(tokens, startIndex)
+	if stop == -1 || stop == startIndex+1 {
+		return nil
+	}
+	var args [][]Token
+	depth, from := 0, startIndex+1
+	for i := startIndex + 1; i < stop; i++ {
+		switch {
+		case tokens[i].TSubType == TokenSubTypeStart:
+			depth++
+		case tokens[i].TSubType == TokenSubTypeStop:
+			depth--
+		case depth == 0 && tokens[i].TType == TokenTypeArgument:
+			args = append(args, tokens[from:i])
+			from = i + 1
+		}
+	}
+	return append(args, tokens[from:stop])
+}
+
 // doubleChar provides function to get two characters after the current
 // position.
 // 返回公式中相对于偏移量的最后两个字符,如果没有比偏移量大2个值的索引了,返回空字符串
 func (ps *Parser) doubleChar() string {
-	//将公式转换为字符值数组,并检验其长度是否比偏移量至少大于2
-	if len([]rune(ps.Formula)) >= ps.Offset+2 {
+	//检验公式字符数组的长度是否比偏移量至少大于2
+	if len(ps.runes) >= ps.Offset+2 {
 		//返回最后两个字符
-		return string([]rune(ps.Formula)[ps.Offset : ps.Offset+2])
+		return string(ps.runes[ps.Offset : ps.Offset+2])
 	}
 	return ""
 }
@@ -646,14 +1337,66 @@ func (ps *Parser) doubleChar() string {
 // currentChar provides function to get the character of the current position.
 // 返回当前位置(偏移量)相对的当前字符
 func (ps *Parser) currentChar() string {
-	return string([]rune(ps.Formula)[ps.Offset])
+	return string(ps.runes[ps.Offset])
+}
+
+// isWhitespace provides a method to check if the character separates tokens
+// as a space: only " ", or also line breaks and tabs in multi-line mode.
+// 判断字符是否为空白
+func (ps *Parser) isWhitespace(c string) bool {
+	return c == " " || ps.MultiLine && (c == "\n" || c == "\r" || c == "\t")
+}
+
+// errorValueAt provides a method to check if one of the error values, such
+// as #N/A, starts at offset of the formula.
+// 判断指定位置是否以错误值开始
+func (ps *Parser) errorValueAt(offset int) bool {
+	rest := string(ps.runes[offset:])
+	for value := range errorValues {
+		if strings.HasPrefix(rest, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipWhitespace provides a method to get the offset of the first character
+// at or after offset which is not whitespace.
+// 返回指定位置之后第一个非空白字符的位置
+func (ps *Parser) skipWhitespace(offset int) int {
+	for offset < len(ps.runes) && ps.isWhitespace(string(ps.runes[offset])) {
+		offset++
+	}
+	return offset
+}
+
+// setLineColumns provides a method to set the line and column of each token
+// from its start offset, by counting the line breaks of the formula before it
+// in a single pass, as the tokens are in order of their start offsets.
+// 根据起始位置设置每个标记所在的行和列
+func setLineColumns(formula string, tokens []Token) {
+	f := []rune(formula)
+	offset, line, column := 0, 1, 1
+	for i := range tokens {
+		if tokens[i].Start < offset {
+			offset, line, column = 0, 1, 1 //标记位置回退时从头计数
+		}
+		for ; offset < tokens[i].Start; offset++ {
+			if f[offset] == '\n' {
+				line, column = line+1, 1
+			} else {
+				column++
+			}
+		}
+		tokens[i].Line, tokens[i].Column = line, column
+	}
 }
 
 // nextChar provides function to get the next character of the current position.
 // 返回当前位置(偏移量相对应)下一个字符
 func (ps *Parser) nextChar() string {
-	if len([]rune(ps.Formula)) >= ps.Offset+2 {
-		return string([]rune(ps.Formula)[ps.Offset+1 : ps.Offset+2])
+	if len(ps.runes) >= ps.Offset+2 {
+		return string(ps.runes[ps.Offset+1 : ps.Offset+2])
 	}
 	return ""
 }
@@ -661,60 +1404,2362 @@ func (ps *Parser) nextChar() string {
 // EOF provides function to check whether or not end of tokens stack.
 // 判断是否最后一个字符
 func (ps *Parser) EOF() bool {
-	return ps.Offset >= len([]rune(ps.Formula))
+	return ps.Offset >= len(ps.runes)
 }
 
-// Parse provides function to parse formula as a token stream (list).
-// 解析公式字符串
+// Parse provides function to parse formula as a token stream (list). The
+// first "=" is the formula marker, prepended when missing, and is not
+// tokenized; every later "=" is a comparison operator.
+// 解析公式字符串,开头的等号为公式标记,其后的等号均为比较操作符
 func (ps *Parser) Parse(formula string) []Token {
 	ps.Formula = formula
+	ps.Tokens, ps.TokenStack = fTokens(), fTokens()
+	ps.Offset, ps.Token = 0, ""
+	ps.InString, ps.InPath, ps.InRange, ps.InError = false, false, false, false
 	ps.Tokens = ps.getTokens(formula)
 	return ps.Tokens.Items
 }
 
-// PrettyPrint provides function to pretty the parsed result with the indented
-// format.
-// 以缩进格式打印解析结果
-func (ps *Parser) PrettyPrint() string {
-	indent := 0
-	output := ""
-	for _, t := range ps.Tokens.Items {
-		if t.TSubType == TokenSubTypeStop {
-			indent--
+// items provides a method to get the parsed tokens without the final
+// TokenTypeEOF token EmitEOF appends, for the methods analysing the formula.
+// 返回不含结束标记的标记列表
+func (ps *Parser) items() []Token {
+	items := ps.Tokens.Items
+	if n := len(items); n > 0 && items[n-1].TType == TokenTypeEOF {
+		return items[:n-1]
+	}
+	return items
+}
+
+// ParseChecked provides function to parse formula as a token stream (list),
+// returning an error for input the lenient Parse would silently accept.
+// 解析公式字符串,并检查公式中的错误
+func (ps *Parser) ParseChecked(formula string) ([]Token, error) {
+	if ps.StrictEquals && !strings.HasPrefix(strings.TrimSpace(formula), "=") {
+		return nil, errors.New("formula must begin with \"=\"")
+	}
+	tokens := ps.Parse(formula)
+	for _, check := range []func([]Token) error{
+		checkPostfixOperators,
+		checkComparisonOperators,
+		checkTrailingOperators,
+	} {
+		if err := check(tokens); err != nil {
+			return tokens, err
 		}
-		for i := 0; i < indent; i++ {
-			output += "\t"
+	}
+	return tokens, nil
+}
+
+// FirstError provides function to parse formula and get the first problem
+// which makes it invalid: unbalanced delimiters, unterminated strings and
+// sheet names, misplaced operators and separators, or operators missing an
+// operand. It returns nil for valid formulas.
+// 解析公式并返回第一个语法错误,公式有效时返回nil
+func (ps *Parser) FirstError(formula string) *SyntaxError {
+	_, err := ps.ParseChecked(formula)
+	if err == nil {
+		err = ps.ValidateDelimiters()
+	}
+	if err == nil {
+		_, err = ps.ast()
+	}
+	if err == nil {
+		return nil
+	}
+	if se, ok := err.(*SyntaxError); ok {
+		return se
+	}
+	return &SyntaxError{Offset: len(ps.runes), Message: err.Error()}
+}
+
+// checkComparisonOperators provides a method to check that no comparison
+// operator directly follows another, such as the "><" or "=<" typos for the
+// "<>" and "<=" operators.
+// 检查是否有相邻的比较操作符
+func checkComparisonOperators(tokens []Token) error {
+	for i := 1; i < len(tokens); i++ {
+		prev, t := tokens[i-1], tokens[i]
+		if prev.TType == TokenTypeOperatorInfix && prev.TSubType == TokenSubTypeLogical && t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeLogical {
+			return &SyntaxError{Offset: prev.Start, Message: fmt.Sprintf("invalid operator %q", prev.TValue+t.TValue)}
 		}
-		output += t.TValue + " <" + t.TType + "> <" + t.TSubType + ">" + "\n"
-		if t.TSubType == TokenSubTypeStart {
-			indent++
+	}
+	return nil
+}
+
+// checkTrailingOperators provides a method to check that every infix and
+// prefix operator is followed by an operand, such as the "+" ending =A1+.
+// 检查中缀和前缀操作符之后是否有操作数
+func checkTrailingOperators(tokens []Token) error {
+	for i, t := range tokens {
+		if t.TType != TokenTypeOperatorInfix && t.TType != TokenTypeOperatorPrefix {
+			continue
+		}
+		next := Token{TType: TokenTypeEOF}
+		for _, n := range tokens[i+1:] {
+			if n.TType != TokenTypeNoop {
+				next = n
+				break
+			}
+		}
+		if next.TType == TokenTypeEOF || next.TType == TokenTypeArgument || next.TSubType == TokenSubTypeStop {
+			return &SyntaxError{Offset: t.Start, Message: fmt.Sprintf("missing operand after %q", t.TValue)}
 		}
 	}
-	return output
+	return nil
 }
 
-// Render provides function to get formatted formula after parsed.
-// 解析好后格式化的公式
-func (ps *Parser) Render() string {
-	output := ""
+// checkPostfixOperators provides a method to check that every postfix
+// operator follows an operand, a closing parenthesis or another postfix
+// operator.
+// 检查后缀操作符之前是否有操作数
+func checkPostfixOperators(tokens []Token) error {
+	for i, t := range tokens {
+		if t.TType != TokenTypeOperatorPostfix {
+			continue
+		}
+		if i > 0 {
+			prev := tokens[i-1]
+			if prev.TType == TokenTypeOperand || prev.TType == TokenTypeOperatorPostfix || prev.TSubType == TokenSubTypeStop {
+				continue
+			}
+		}
+		return &SyntaxError{Offset: t.Start, Message: fmt.Sprintf("unexpected %q", t.TValue)}
+	}
+	return nil
+}
+
+// SetFunctionRewriter provides function to set a callback which rewrites the
+// name of each function call during parse, after the "@" and
+// FunctionPrefixes have been stripped. The internal ARRAY and ARRAYROW tokens are not passed to the
+// callback. Passing nil disables rewriting.
+// 设置解析时改写函数名的回调函数
+func (ps *Parser) SetFunctionRewriter(fn func(name string) string) {
+	ps.functionRewriter = fn
+}
+
+// SetOperandClassifier provides function to set a callback which gets the
+// subtype of each operand during parse, other than text and error literals,
+// such as to detect dates. When the callback returns an empty subtype, the
+// operand is subtyped as usual. Passing nil disables the callback.
+// 设置解析时获取操作数子类型的回调函数
+func (ps *Parser) SetOperandClassifier(fn func(value string) string) {
+	ps.operandClassifier = fn
+}
+
+// TokenAt provides function to get the parsed token whose span covers the
+// given rune offset of the formula. Offsets index ps.Formula, which always
+// starts with "=". Offsets that fall on whitespace or between tokens return
+// false.
+// 返回覆盖指定字符偏移量的标记
+func (ps *Parser) TokenAt(runeOffset int) (Token, bool) {
 	for _, t := range ps.Tokens.Items {
-		if t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStart {
-			output += t.TValue + "("
-		} else if t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStop {
-			output += ")"
-		} else if t.TType == TokenTypeSubexpression && t.TSubType == TokenSubTypeStart {
-			output += "("
-		} else if t.TType == TokenTypeSubexpression && t.TSubType == TokenSubTypeStop {
-			output += ")"
-		} else if t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeText {
-			output += "\"" + t.TValue + "\""
-		} else if t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeIntersection {
-			output += " "
-		} else {
-			output += t.TValue
+		if t.Start <= runeOffset && runeOffset < t.End {
+			return t, true
 		}
 	}
-	return output
+	return Token{}, false
+}
+
+// HasSubexpression provides function to check whether the parsed formula
+// uses grouping parentheses, as opposed to only function call parentheses.
+// 判断解析好的公式中是否包含子表达式
+func (ps *Parser) HasSubexpression() bool {
+	for _, t := range ps.items() {
+		if t.TType == TokenTypeSubexpression {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint provides function to get a hash of the parsed token stream
+// which ignores insignificant whitespace and the case of function names,
+// references, names, logical and error values. Text literals remain case
+// sensitive.
+// 返回解析结果的指纹,忽略空白以及函数名、引用等的大小写
+func (ps *Parser) Fingerprint() uint64 {
+	return ps.fingerprint(false)
+}
+
+// FingerprintCaseSensitive provides function to get a hash of the parsed
+// token stream like Fingerprint, except that the case of every value,
+// including function names and references, is significant.
+// 返回解析结果的指纹,区分大小写
+func (ps *Parser) FingerprintCaseSensitive() uint64 {
+	return ps.fingerprint(true)
+}
+
+// fingerprint provides a method to hash the parsed token stream.
+// 计算解析结果的指纹
+func (ps *Parser) fingerprint(caseSensitive bool) uint64 {
+	h := fnv.New64a()
+	for _, t := range ps.items() {
+		value := t.TValue
+		if !caseSensitive && t.TSubType != TokenSubTypeText {
+			value = strings.ToUpper(value)
+		}
+		h.Write([]byte(t.TType + "\x00" + t.TSubType + "\x00" + value + "\x00"))
+	}
+	return h.Sum64()
+}
+
+// Equivalent provides function to check whether two formulas parse to the
+// same token stream, as compared by Fingerprint. Whitespace other than the
+// intersection operator, a missing leading "=", and the case of function
+// names and references are ignored; everything else, including the order of
+// operands and text literal casing, must match.
+// 判断两个公式是否等价
+func Equivalent(a, b string) bool {
+	pa, pb := ExcelParser(), ExcelParser()
+	pa.Parse(a)
+	pb.Parse(b)
+	return pa.Fingerprint() == pb.Fingerprint()
+}
+
+// IsConstant provides function to check whether the parsed formula is a
+// single literal number, text, logical or error value, with no operators,
+// references or function calls.
+// 判断解析好的公式是否为单个常量
+func (ps *Parser) IsConstant() bool {
+	items := ps.items()
+	if len(items) != 1 || items[0].TType != TokenTypeOperand {
+		return false
+	}
+	switch items[0].TSubType {
+	case TokenSubTypeNumber, TokenSubTypePercent, TokenSubTypeText, TokenSubTypeLogical, TokenSubTypeError:
+		return true
+	}
+	return false
+}
+
+// ParseName provides function to split a defined name operand value into
+// the sheet it is scoped to, unquoted, and the name, such as "Sheet1" and
+// "LocalName" for Sheet1!LocalName. Workbook scoped names have an empty
+// sheet. It returns false if the value is not a name.
+// 解析定义的名称及其所属的工作表
+func ParseName(value string) (sheet, name string, ok bool) {
+	name = value
+	if idx := strings.LastIndex(value, "!"); idx != -1 {
+		sheet, name = value[:idx], value[idx+1:]
+		if len(sheet) >= 2 && strings.HasPrefix(sheet, "'") && strings.HasSuffix(sheet, "'") {
+			sheet = strings.Replace(sheet[1:len(sheet)-1], "''", "'", -1)
+		}
+		if sheet == "" {
+			return "", "", false
+		}
+	}
+	if !isName(name) {
+		return "", "", false
+	}
+	return sheet, name, true
+}
+
+// ParseTableReference provides function to split a structured reference to
+// an Excel table into the workbook index or name of an external reference,
+// the table name and the column specifier, such as "1", "Table1" and
+// "[Amount]" for [1]!Table1[Amount]. The table name is empty for references
+// inside the table itself such as [@Qty], and a leading implicit
+// intersection "@" is not part of it. It returns false if the value is not a
+// structured reference.
+// 解析表格的结构化引用
+func ParseTableReference(value string) (workbook, table, specifier string, ok bool) {
+	if m := workbookPattern.FindString(value); m != "" && strings.HasPrefix(value[len(m):], "!") {
+		workbook, value = m[1:len(m)-1], value[len(m)+1:]
+	}
+	if !structuredPattern.MatchString(value) {
+		return "", "", "", false
+	}
+	idx := strings.Index(value, "[")
+	return workbook, strings.TrimPrefix(value[:idx], "@"), value[idx:], true
+}
+
+// ClassifyReference provides function to get the kind of a reference operand
+// value, optionally qualified by a sheet: TokenSubTypeCell for a single cell
+// in A1 or R1C1 style, TokenSubTypeColumn or TokenSubTypeRow for whole
+// columns or rows, and TokenSubTypeRange for cell ranges and spilled ranges.
+// It returns an empty string if the value is not a reference.
+// 返回引用的类型
+func ClassifyReference(value string) string {
+	ref, ok := parseReference(value)
+	if !ok {
+		if idx := strings.LastIndex(value, "!"); idx != -1 {
+			value = value[idx+1:]
+		}
+		if r1c1CellPattern.MatchString(value) {
+			return TokenSubTypeCell
+		}
+		if referencePattern.MatchString(value) {
+			return TokenSubTypeRange
+		}
+		return ""
+	}
+	switch {
+	case ref.spill || len(ref.cells) == 2 && ref.cells[0].col > 0 && ref.cells[0].row > 0:
+		return TokenSubTypeRange
+	case ref.cells[0].row == 0:
+		return TokenSubTypeColumn
+	case ref.cells[0].col == 0:
+		return TokenSubTypeRow
+	}
+	return TokenSubTypeCell
+}
+
+// ShiftReferences provides function to move every relative part of the A1
+// style references in the parsed formula by the given number of rows and
+// columns, as Excel does when a formula is copied, and get the resulting
+// formula with a leading "=". Parts anchored with "$" are left unchanged. It
+// returns an error if a reference would move off the sheet.
+// 按照指定的行数和列数移动公式中的相对引用
+func (ps *Parser) ShiftReferences(rows, cols int) (string, error) {
+	tokens := append([]Token(nil), ps.items()...)
+	for i, t := range tokens {
+		if !isReferenceToken(t) {
+			continue
+		}
+		ref, ok := parseReference(t.TValue)
+		if !ok {
+			continue
+		}
+		for j, c := range ref.cells {
+			if c.col > 0 && !c.colAbs {
+				if c.col += cols; c.col < 1 || c.col > maxColumns {
+					return "", fmt.Errorf("reference %s moves off the sheet", t.TValue)
+				}
+			}
+			if c.row > 0 && !c.rowAbs {
+				if c.row += rows; c.row < 1 || c.row > maxRows {
+					return "", fmt.Errorf("reference %s moves off the sheet", t.TValue)
+				}
+			}
+			ref.cells[j] = c
+		}
+		tokens[i].TValue = ref.String()
+	}
+	return renderTokens(tokens, LocaleUS), nil
+}
+
+// AnchorReferences provides function to make every part of the A1 style
+// references in the parsed formula absolute, with "$", or relative, without
+// it, and get the resulting formula with a leading "=". It returns an error
+// in R1C1 mode, where references are not read in A1 style.
+// 将公式中的引用全部转换为绝对引用或相对引用
+func (ps *Parser) AnchorReferences(absolute bool) (string, error) {
+	if ps.R1C1 {
+		return "", errors.New("references in R1C1 mode can't be anchored")
+	}
+	tokens := append([]Token(nil), ps.items()...)
+	for i, t := range tokens {
+		if !isReferenceToken(t) {
+			continue
+		}
+		ref, ok := parseReference(t.TValue)
+		if !ok {
+			continue
+		}
+		for j := range ref.cells {
+			ref.cells[j].colAbs, ref.cells[j].rowAbs = absolute, absolute
+		}
+		tokens[i].TValue = ref.String()
+	}
+	return renderTokens(tokens, LocaleUS), nil
+}
+
+// Precedence provides function to get the precedence of an operator token
+// in Excel, where a higher value binds tighter. From the highest: the range
+// operator ":" and the spilled range "#", intersection, union, negation "-",
+// percent "%", exponentiation "^", multiplication and division, addition and
+// subtraction, concatenation "&", and the comparison operators. It returns 0
+// for tokens which are not operators.
+// 返回操作符标记的优先级,值越大优先级越高,非操作符返回0
+func Precedence(t Token) int {
+	switch t.TType {
+	case TokenTypeOperatorPrefix:
+		return 7
+	case TokenTypeOperatorPostfix:
+		if t.TSubType == TokenSubTypeSpill {
+			return 10
+		}
+		return 6
+	case TokenTypeOperatorInfix:
+		switch t.TSubType {
+		case TokenSubTypeRange, TokenSubType3DRange:
+			return 10
+		case TokenSubTypeIntersection:
+			return 9
+		case TokenSubTypeUnion:
+			return 8
+		case TokenSubTypeConcatenation:
+			return 2
+		case TokenSubTypeLogical:
+			return 1
+		}
+		switch t.TValue {
+		case "^":
+			return 5
+		case "*", "/":
+			return 4
+		case "+", "-":
+			return 3
+		}
+	}
+	return 0
+}
+
+// FoldStringConcatenation provides function to merge every concatenation of
+// two text literals in the parsed tokens into a single text operand, such as
+// ="a"&"b"&"c" into ="abc", repeatedly until no more folds apply. Literals
+// bound more tightly to a neighbouring operator, such as "a" in =A1+"a"&"b",
+// are left unchanged.
+// 合并公式中相互连接的文本常量
+func (ps *Parser) FoldStringConcatenation() {
+	isText := func(t Token) bool { return t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeText }
+	concat := Precedence(Token{TType: TokenTypeOperatorInfix, TSubType: TokenSubTypeConcatenation})
+	items := append([]Token(nil), ps.Tokens.Items...)
+	for folded := true; folded; {
+		folded = false
+		for i := 1; i+1 < len(items); i++ {
+			if items[i].TSubType != TokenSubTypeConcatenation || !isText(items[i-1]) || !isText(items[i+1]) {
+				continue
+			}
+			if i >= 2 && Precedence(items[i-2]) > concat {
+				continue
+			}
+			if i+2 < len(items) && Precedence(items[i+2]) > concat {
+				continue
+			}
+			merged := items[i-1]
+			merged.TValue += items[i+1].TValue
+			merged.End = items[i+1].End
+			items = append(append(items[:i-1], merged), items[i+2:]...)
+			folded = true
+			break
+		}
+	}
+	ps.Tokens.Items = items
+}
+
+// FoldPercentages provides function to merge every number literal directly
+// followed by a percent operator in the parsed tokens, such as the elements
+// of ={10%,20%}, into a single operand of TokenSubTypePercent whose value
+// keeps the trailing "%". The percent operator binds the most tightly, so the
+// fold never changes the meaning of the formula.
+// 将数字常量与其后的百分号合并为百分数操作数
+func (ps *Parser) FoldPercentages() {
+	items := ps.Tokens.Items
+	folded := make([]Token, 0, len(items))
+	for _, t := range items {
+		if n := len(folded); n > 0 && t.TType == TokenTypeOperatorPostfix && t.TValue == "%" &&
+			folded[n-1].TType == TokenTypeOperand && folded[n-1].TSubType == TokenSubTypeNumber {
+			folded[n-1].TValue += t.TValue
+			folded[n-1].TSubType = TokenSubTypePercent
+			folded[n-1].End = t.End
+			continue
+		}
+		folded = append(folded, t)
+	}
+	ps.Tokens.Items = folded
+}
+
+// PrecedenceLevelsUsed provides function to count the distinct precedence
+// levels, as given by Precedence, of the operators in the parsed formula.
+// 统计解析好的公式中的操作符使用了多少个不同的优先级
+func (ps *Parser) PrecedenceLevelsUsed() int {
+	levels := map[int]struct{}{}
+	for _, t := range ps.items() {
+		if p := Precedence(t); p > 0 {
+			levels[p] = struct{}{}
+		}
+	}
+	return len(levels)
+}
+
+// Associativity provides function to check whether an infix operator is
+// left-associative, that is, whether a chain of operators of the same
+// precedence is evaluated from left to right. Excel evaluates every infix
+// operator from left to right, including "^": =2^3^2 is (2^3)^2 = 64. Note
+// that this departs from the mathematical convention, where exponentiation
+// groups from the right and 2^3^2 is 2^(3^2) = 512. Only the prefix operators
+// group from the right, which follows from their being unary.
+// 判断中缀操作符是否为左结合,EXCEL中所有的中缀操作符(包括^)均为左结合
+func Associativity(operator string) (leftAssoc bool) {
+	return true
+}
+
+// ToRPN provides function to convert the parsed formula to Reverse Polish
+// Notation with the shunting-yard algorithm. Parentheses are dropped, and
+// each function call is emitted after its arguments as an arity marker, an
+// Argument token whose value is the number of arguments, followed by the
+// function's Start token. Array constants are emitted the same way as ARRAY
+// and ARRAYROW calls. Omitted arguments, such as the second and third of
+// =IF(A1,,), are emitted as operands of TokenSubTypeNothing with an empty
+// value.
+// 使用调度场算法将解析好的公式转换为逆波兰表示法
+func (ps *Parser) ToRPN() ([]Token, error) {
+	type frame struct {
+		token    Token
+		args     int
+		nonEmpty bool
+		argument bool // 当前参数是否有内容
+	}
+	omitted := fToken("", TokenTypeOperand, TokenSubTypeNothing)
+	var output []Token
+	var stack []*frame
+	// popOperators moves operators from the stack to the output until a
+	// group start or an operator the keep function rejects is on top.
+	popOperators := func(keep func(Token) bool) {
+		for len(stack) > 0 {
+			top := stack[len(stack)-1].token
+			if top.TSubType == TokenSubTypeStart || keep(top) {
+				return
+			}
+			output = append(output, top)
+			stack = stack[:len(stack)-1]
+		}
+	}
+	all := func(Token) bool { return false }
+	for _, t := range ps.items() {
+		if t.TType == TokenTypeNoop {
+			continue
+		}
+		if n := len(stack); t.TSubType != TokenSubTypeStop {
+			for i := n - 1; i >= 0; i-- {
+				if stack[i].token.TSubType == TokenSubTypeStart {
+					stack[i].nonEmpty = true
+					stack[i].argument = stack[i].argument || t.TType != TokenTypeArgument
+					break
+				}
+			}
+		}
+		switch {
+		case t.TType == TokenTypeOperand:
+			output = append(output, t)
+		case t.TSubType == TokenSubTypeStart:
+			stack = append(stack, &frame{token: t})
+		case t.TType == TokenTypeArgument:
+			popOperators(all)
+			if len(stack) == 0 || stack[len(stack)-1].token.TType != TokenTypeFunction {
+				return nil, &SyntaxError{Offset: t.Start, Message: "unexpected argument separator"}
+			}
+			if top := stack[len(stack)-1]; !top.argument {
+				output = append(output, omitted)
+			}
+			stack[len(stack)-1].args++
+			stack[len(stack)-1].argument = false
+		case t.TSubType == TokenSubTypeStop:
+			popOperators(all)
+			if len(stack) == 0 {
+				return nil, &SyntaxError{Offset: t.Start, Message: "unbalanced parenthesis"}
+			}
+			group := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if group.token.TType == TokenTypeFunction {
+				if group.args > 0 && !group.argument {
+					output = append(output, omitted)
+				}
+				arity := group.args
+				if group.nonEmpty {
+					arity++
+				}
+				output = append(output, fToken(strconv.Itoa(arity), TokenTypeArgument, ""), group.token)
+			}
+		case t.TType == TokenTypeOperatorPrefix:
+			stack = append(stack, &frame{token: t})
+		case t.TType == TokenTypeOperatorPostfix:
+			popOperators(func(top Token) bool { return Precedence(top) <= Precedence(t) })
+			output = append(output, t)
+		case t.TType == TokenTypeOperatorInfix:
+			popOperators(func(top Token) bool {
+				return Precedence(top) < Precedence(t) || (Precedence(top) == Precedence(t) && !Associativity(t.TValue))
+			})
+			stack = append(stack, &frame{token: t})
+		default:
+			return nil, &SyntaxError{Offset: t.Start, Message: fmt.Sprintf("unexpected %q", t.TValue)}
+		}
+	}
+	popOperators(all)
+	if len(stack) > 0 {
+		return nil, &SyntaxError{Offset: stack[len(stack)-1].token.Start, Message: "unclosed parenthesis"}
+	}
+	return output, nil
+}
+
+// MaxArgumentCount provides function to get the largest number of arguments
+// passed to any function call in the parsed formula, including nested calls,
+// as counted by the arity markers of ToRPN. Array constants are not counted.
+// It returns 0 when the formula has no function calls or is malformed.
+// 返回公式中所有函数调用的最大参数个数
+func (ps *Parser) MaxArgumentCount() int {
+	rpn, err := ps.ToRPN()
+	if err != nil {
+		return 0
+	}
+	max := 0
+	for i := 1; i < len(rpn); i++ {
+		if t := rpn[i]; t.TType != TokenTypeFunction || isArrayToken(t) || rpn[i-1].TType != TokenTypeArgument {
+			continue
+		}
+		if arity, _ := strconv.Atoi(rpn[i-1].TValue); arity > max {
+			max = arity
+		}
+	}
+	return max
+}
+
+// DeprecatedFunctions provides function to find the function calls in the
+// parsed formula whose names are keys of mapping, compared
+// case-insensitively, and get a map from each name as written in the formula
+// to its suggested replacement.
+// 查找公式中已弃用的函数,并返回建议的替代函数
+func (ps *Parser) DeprecatedFunctions(mapping map[string]string) map[string]string {
+	upper := make(map[string]string, len(mapping))
+	for name, replacement := range mapping {
+		upper[strings.ToUpper(name)] = replacement
+	}
+	found := map[string]string{}
+	for _, t := range ps.items() {
+		if t.TType != TokenTypeFunction || t.TSubType != TokenSubTypeStart || isArrayToken(t) {
+			continue
+		}
+		if replacement, ok := upper[strings.ToUpper(t.TValue)]; ok {
+			found[t.TValue] = replacement
+		}
+	}
+	return found
+}
+
+// UsesExternalData provides function to check if the parsed formula calls
+// any of ExternalDataFunctions, compared case-insensitively.
+// 判断公式是否调用了获取外部数据的函数
+func (ps *Parser) UsesExternalData() bool {
+	return ps.callsAny(ExternalDataFunctions)
+}
+
+// SpillsArray provides function to check if the parsed formula calls any of
+// SpillFunctions, compared case-insensitively, and so spills a dynamic array.
+// 判断公式是否调用了返回动态数组的函数
+func (ps *Parser) SpillsArray() bool {
+	return ps.callsAny(SpillFunctions)
+}
+
+// SafeToInline provides function to check if the parsed formula can be
+// substituted into another formula without changing its results, that is it
+// calls none of VolatileFunctions, ExternalDataFunctions or SpillFunctions.
+// 判断公式是否可以安全地内联到其他公式中
+func (ps *Parser) SafeToInline() bool {
+	return !ps.callsAny(VolatileFunctions) && !ps.callsAny(ExternalDataFunctions) && !ps.callsAny(SpillFunctions)
+}
+
+// callsAny provides a method to check if the parsed formula calls any of the
+// given upper case function names, compared case-insensitively.
+// 判断公式是否调用了指定的任一函数
+func (ps *Parser) callsAny(functions map[string]struct{}) bool {
+	for _, t := range ps.items() {
+		if t.TType != TokenTypeFunction || t.TSubType != TokenSubTypeStart || isArrayToken(t) {
+			continue
+		}
+		if _, ok := functions[strings.ToUpper(t.TValue)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DynamicReferenceArgs provides function to get every INDIRECT and OFFSET
+// call in the parsed formula, in document order, with a copy of the tokens
+// of each of its arguments, such as the text "Sheet1!A1" of
+// =INDIRECT("Sheet1!A1"). The references these functions return are built at
+// calculation time, so they don't appear as reference operands.
+// 返回INDIRECT和OFFSET函数调用及其各个参数的标记
+func (ps *Parser) DynamicReferenceArgs() []DynamicReferenceCall {
+	items := ps.items()
+	var calls []DynamicReferenceCall
+	for i, t := range items {
+		if t.TType != TokenTypeFunction || t.TSubType != TokenSubTypeStart {
+			continue
+		}
+		switch strings.ToUpper(t.TValue) {
+		case "INDIRECT", "OFFSET":
+			call := DynamicReferenceCall{Function: t}
+			for _, arg := range functionArguments(items, i) {
+				call.Args = append(call.Args, append([]Token(nil), arg...))
+			}
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ThreeDReferences provides function to get the references in the parsed
+// formula which span a range of sheets, such as Sheet1:Sheet3!A1, in order.
+// With SplitRanges, the pieces of each reference are put back together.
+// 返回公式中跨工作表的三维引用
+func (ps *Parser) ThreeDReferences() []string {
+	var refs []string
+	items := ps.items()
+	for i := 0; i < len(items); i++ {
+		t := items[i]
+		ref := ""
+		if t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubType3DRange && i > 0 && i+1 < len(items) {
+			ref = items[i-1].TValue + t.TValue + items[i+1].TValue
+			i++
+		} else if idx := strings.LastIndex(t.TValue, "!"); isReferenceToken(t) && idx != -1 && strings.Contains(t.TValue[:idx], ":") {
+			ref = t.TValue
+		} else {
+			continue
+		}
+		for i+2 < len(items) && items[i+1].TType == TokenTypeOperatorInfix && items[i+1].TSubType == TokenSubTypeRange {
+			ref += items[i+1].TValue + items[i+2].TValue
+			i += 2
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// ReferencedColumns provides function to get the distinct column letters
+// covered by the A1 style references in the parsed formula, in column order,
+// expanding ranges such as A1:C1 and A:C to A, B and C. Sheets are not told
+// apart, and whole row references, which cover every column, are skipped.
+// 返回公式中的引用所涉及的全部列
+func (ps *Parser) ReferencedColumns() []string {
+	seen := map[int]struct{}{}
+	for _, t := range ps.items() {
+		if !isReferenceToken(t) {
+			continue
+		}
+		ref, ok := parseReference(t.TValue)
+		if !ok || ref.cells[0].col == 0 {
+			continue
+		}
+		from, to := ref.cells[0].col, ref.cells[len(ref.cells)-1].col
+		if from > to {
+			from, to = to, from
+		}
+		for col := from; col <= to; col++ {
+			seen[col] = struct{}{}
+		}
+	}
+	cols := make([]int, 0, len(seen))
+	for col := range seen {
+		cols = append(cols, col)
+	}
+	sort.Ints(cols)
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = columnName(col)
+	}
+	return names
+}
+
+// TextLiterals provides function to get the values of every text operand in
+// the parsed formula, in order, with embedded double quotes un-doubled.
+// 返回公式中所有的文本常量
+func (ps *Parser) TextLiterals() []string {
+	var literals []string
+	for _, t := range ps.items() {
+		if t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeText {
+			literals = append(literals, t.TValue)
+		}
+	}
+	return literals
+}
+
+// Metrics provides function to count the references and names, function
+// calls, operators and literals of the parsed formula, and measure the
+// maximum nesting depth of function calls, subexpressions and arrays.
+// 统计解析好的公式的各项指标
+func (ps *Parser) Metrics() FormulaMetrics {
+	var m FormulaMetrics
+	depth := 0
+	for _, t := range ps.items() {
+		switch {
+		case t.TSubType == TokenSubTypeStart:
+			if depth++; depth > m.Depth {
+				m.Depth = depth
+			}
+			if t.TType == TokenTypeFunction && !isArrayToken(t) {
+				m.Functions++
+			}
+		case t.TSubType == TokenSubTypeStop:
+			depth--
+		case isReferenceToken(t), t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeName:
+			m.References++
+		case t.TType == TokenTypeOperand:
+			m.Literals++
+		case t.TType == TokenTypeOperatorPrefix, t.TType == TokenTypeOperatorInfix, t.TType == TokenTypeOperatorPostfix:
+			m.Operators++
+		}
+	}
+	return m
+}
+
+// Requalify provides function to prefix every reference in the parsed
+// formula which has no sheet with fromSheet, quoted when needed, so that the
+// formula keeps pointing at the original sheet when moved to another one,
+// and get the resulting formula with a leading "=".
+// 为公式中没有工作表前缀的引用加上原工作表名
+func (ps *Parser) Requalify(fromSheet string) (string, error) {
+	if fromSheet == "" {
+		return "", errors.New("sheet name must not be empty")
+	}
+	prefix := quoteSheetName(fromSheet) + "!"
+	tokens := append([]Token(nil), ps.items()...)
+	for i, t := range tokens {
+		if isReferenceToken(t) && !strings.Contains(t.TValue, "!") && ClassifyReference(t.TValue) != "" {
+			tokens[i].TValue = prefix + t.TValue
+		}
+	}
+	return renderTokens(tokens, LocaleUS), nil
+}
+
+// ParseAST provides function to parse formula into an abstract syntax tree.
+// Grouping parentheses are not kept in the tree, as its shape already
+// expresses the order of evaluation.
+// 将公式解析为抽象语法树
+func ParseAST(formula string) (*Node, error) {
+	ps := ExcelParser()
+	ps.Parse(formula)
+	return ps.ast()
+}
+
+// ast provides a method to build the abstract syntax tree of the parsed
+// formula from its Reverse Polish Notation.
+// 根据逆波兰表示法构建解析好的公式的抽象语法树
+func (ps *Parser) ast() (*Node, error) {
+	rpn, err := ps.ToRPN()
+	if err != nil {
+		return nil, err
+	}
+	var stack []*Node
+	pop := func(t Token, n int) ([]*Node, error) {
+		if len(stack) < n {
+			return nil, &SyntaxError{Offset: t.Start, Message: fmt.Sprintf("missing operand for %q", t.TValue)}
+		}
+		children := append([]*Node(nil), stack[len(stack)-n:]...)
+		stack = stack[:len(stack)-n]
+		return children, nil
+	}
+	arity := 0
+	for _, t := range rpn {
+		n := 0
+		switch t.TType {
+		case TokenTypeArgument:
+			arity, _ = strconv.Atoi(t.TValue)
+			continue
+		case TokenTypeFunction:
+			n = arity
+		case TokenTypeOperatorPrefix, TokenTypeOperatorPostfix:
+			n = 1
+		case TokenTypeOperatorInfix:
+			n = 2
+		}
+		children, err := pop(t, n)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, &Node{Token: t, Children: children})
+	}
+	if len(stack) != 1 {
+		return nil, errors.New("formula is not a single expression")
+	}
+	return stack[0], nil
+}
+
+// BooleanTree provides function to get the boolean expression tree of the
+// parsed formula: the abstract syntax tree with nested AND, OR and XOR calls
+// collapsed into one node with all the operands as children, such as
+// AND(A1,AND(B1,C1)) into AND(A1,B1,C1). NOT calls keep their single child.
+// Any other node is an operand of the boolean expression and is kept as is.
+// 返回布尔表达式树,合并嵌套的AND、OR和XOR函数调用
+func (ps *Parser) BooleanTree() (*Node, error) {
+	root, err := ps.ast()
+	if err != nil {
+		return nil, err
+	}
+	return booleanNode(root), nil
+}
+
+// booleanNode provides a method to collapse the nested AND, OR and XOR calls
+// of the boolean expression rooted at n.
+// 合并以n为根的布尔表达式中嵌套的函数调用
+func booleanNode(n *Node) *Node {
+	if n.Token.TType != TokenTypeFunction {
+		return n
+	}
+	name := strings.ToUpper(n.Token.TValue)
+	switch name {
+	case "AND", "OR", "XOR", "NOT":
+	default:
+		return n
+	}
+	collapsed := &Node{Token: n.Token}
+	for _, child := range n.Children {
+		child = booleanNode(child)
+		if name != "NOT" && child.Token.TType == TokenTypeFunction && strings.ToUpper(child.Token.TValue) == name {
+			collapsed.Children = append(collapsed.Children, child.Children...)
+			continue
+		}
+		collapsed.Children = append(collapsed.Children, child)
+	}
+	return collapsed
+}
+
+// RenderAST provides function to get the formula of an abstract syntax tree,
+// with a leading "=", inserting parentheses only where the precedence of the
+// operators requires them.
+// 将抽象语法树格式化为公式
+func RenderAST(n *Node) string {
+	if n == nil {
+		return ""
+	}
+	// a union at the top level needs parentheses, as Excel doesn't accept =A1,B1
+	if n.Token.TType == TokenTypeOperatorInfix && n.Token.TSubType == TokenSubTypeUnion {
+		return "=(" + renderNode(n) + ")"
+	}
+	return "=" + renderNode(n)
+}
+
+// renderNode provides a method to get the formula text of a node.
+// 将节点格式化为公式文本
+func renderNode(n *Node) string {
+	t := n.Token
+	// group renders a child, in parentheses when it binds looser than n
+	group := func(child *Node, parenthesize bool) string {
+		if parenthesize {
+			return "(" + renderNode(child) + ")"
+		}
+		return renderNode(child)
+	}
+	switch t.TType {
+	case TokenTypeOperand:
+		if t.TSubType == TokenSubTypeText {
+			return "\"" + strings.Replace(t.TValue, "\"", "\"\"", -1) + "\""
+		}
+		return t.TValue
+	case TokenTypeFunction:
+		args := make([]string, len(n.Children))
+		for i, child := range n.Children {
+			args[i] = group(child, child.Token.TType == TokenTypeOperatorInfix && child.Token.TSubType == TokenSubTypeUnion)
+		}
+		switch t.TValue {
+		case "ARRAY":
+			return "{" + strings.Join(args, ";") + "}"
+		case "ARRAYROW":
+			return strings.Join(args, ",")
+		}
+		return t.TValue + "(" + strings.Join(args, ",") + ")"
+	case TokenTypeOperatorPrefix:
+		return t.TValue + group(n.Children[0], nodePrecedence(n.Children[0]) < Precedence(t))
+	case TokenTypeOperatorPostfix:
+		return group(n.Children[0], nodePrecedence(n.Children[0]) < Precedence(t)) + t.TValue
+	case TokenTypeOperatorInfix:
+		op := t.TValue
+		if t.TSubType == TokenSubTypeIntersection {
+			op = " "
+		}
+		left, right := n.Children[0], n.Children[1]
+		leftParens := nodePrecedence(left) < Precedence(t) || (nodePrecedence(left) == Precedence(t) && !Associativity(t.TValue))
+		rightParens := nodePrecedence(right) < Precedence(t) || (nodePrecedence(right) == Precedence(t) && Associativity(t.TValue))
+		return group(left, leftParens) + op + group(right, rightParens)
+	}
+	return t.TValue
+}
+
+// ToDOT provides function to get the tree rooted at the node in the GraphViz
+// DOT language, with one node per token labeled with its value and type, and
+// an edge from each node to each of its children.
+// 以GraphViz DOT格式返回语法树
+func (n *Node) ToDOT() string {
+	var b strings.Builder
+	b.WriteString("digraph AST {\n")
+	id := 0
+	var walk func(n *Node) int
+	walk = func(n *Node) int {
+		self := id
+		id++
+		label := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(n.Token.TValue + " (" + n.Token.TType + ")")
+		fmt.Fprintf(&b, "\tn%d [label=\"%s\"];\n", self, label)
+		for _, child := range n.Children {
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", self, walk(child))
+		}
+		return self
+	}
+	walk(n)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// nodePrecedence provides a method to get the precedence of the operator of
+// a node, or a precedence above every operator for operands and function
+// calls.
+// 返回节点的优先级,操作数和函数调用的优先级高于所有操作符
+func nodePrecedence(n *Node) int {
+	if p := Precedence(n.Token); p > 0 {
+		return p
+	}
+	return math.MaxInt32
+}
+
+// RedundantParens provides function to get the indices of the subexpression
+// Start tokens whose parentheses don't change the order of evaluation given
+// the operator precedence, such as in =(A1)+B1 or =(A1*B1)+C1. Parentheses
+// around a union are always needed, and of doubled parentheses such as in
+// =((A1+B1))*C1 only the inner pair is reported.
+// 返回多余的括号所在的子表达式开始标记的索引
+func (ps *Parser) RedundantParens() []int {
+	items := ps.items()
+	var indices []int
+	for i, t := range items {
+		if t.TType != TokenTypeSubexpression || t.TSubType != TokenSubTypeStart {
+			continue
+		}
+		stop := MatchingStop(items, i)
+		if stop == -1 {
+			continue
+		}
+		inner, union := groupPrecedence(items, i, stop)
+		if union {
+			continue
+		}
+		if i > 0 && stop+1 < len(items) && items[i-1].TType == TokenTypeSubexpression && items[i-1].TSubType == TokenSubTypeStart &&
+			items[stop+1].TType == TokenTypeSubexpression && items[stop+1].TSubType == TokenSubTypeStop {
+			indices = append(indices, i)
+			continue
+		}
+		if i > 0 {
+			if p := Precedence(items[i-1]); p > 0 && inner <= p {
+				continue
+			}
+		}
+		if stop+1 < len(items) {
+			if p := Precedence(items[stop+1]); p > 0 && inner < p {
+				continue
+			}
+		}
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+// groupPrecedence provides a method to get the lowest precedence of the
+// operators outside nested groups between the Start token at start and its
+// Stop token at stop, looking through doubled parentheses, and whether one of
+// them is a union. Groups without operators have a precedence above every
+// operator.
+// 返回分组中最外层操作符的最低优先级,以及是否包含联合操作符
+func groupPrecedence(tokens []Token, start, stop int) (int, bool) {
+	if start+1 < stop && tokens[start+1].TType == TokenTypeSubexpression && MatchingStop(tokens, start+1) == stop-1 {
+		return groupPrecedence(tokens, start+1, stop-1)
+	}
+	lowest, depth := math.MaxInt32, 0
+	for _, t := range tokens[start+1 : stop] {
+		switch {
+		case t.TSubType == TokenSubTypeStart:
+			depth++
+		case t.TSubType == TokenSubTypeStop:
+			depth--
+		case depth == 0:
+			if t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeUnion {
+				return lowest, true
+			}
+			if p := Precedence(t); p > 0 && p < lowest {
+				lowest = p
+			}
+		}
+	}
+	return lowest, false
+}
+
+// Export provides function to get the parsed tokens as ExportToken values,
+// in document order.
+// 以导出格式返回解析好的全部标记
+func (ps *Parser) Export() []ExportToken {
+	items := ps.items()
+	tokens := make([]ExportToken, len(items))
+	for i, t := range items {
+		tokens[i] = ExportToken{Value: t.TValue, Type: t.TType, SubType: t.TSubType}
+	}
+	return tokens
+}
+
+// TokensOfType provides function to get a copy of the parsed tokens of the
+// given type, such as TokenTypeFunction, in document order.
+// 返回指定类型的全部标记
+func (ps *Parser) TokensOfType(tt string) []Token {
+	var tokens []Token
+	for _, t := range ps.items() {
+		if t.TType == tt {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// TokensOfSubtype provides function to get a copy of the parsed tokens of the
+// given subtype, such as TokenSubTypeNumber, in document order.
+// 返回指定子类型的全部标记
+func (ps *Parser) TokensOfSubtype(st string) []Token {
+	var tokens []Token
+	for _, t := range ps.items() {
+		if t.TSubType == st {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// Operators provides function to get a copy of the parsed prefix, infix and
+// postfix operator tokens, in document order.
+// 返回全部的操作符标记
+func (ps *Parser) Operators() []Token {
+	var tokens []Token
+	for _, t := range ps.items() {
+		switch t.TType {
+		case TokenTypeOperatorPrefix, TokenTypeOperatorInfix, TokenTypeOperatorPostfix:
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// MatchingStop provides function to get the index of the Stop token that
+// closes the function, subexpression or array group opened by the Start
+// token at startIndex. It returns -1 if startIndex is not a Start token or
+// the group is not balanced.
+// 返回与指定开始标记相匹配的结束标记的索引
+func MatchingStop(tokens []Token, startIndex int) int {
+	if startIndex < 0 || startIndex >= len(tokens) || tokens[startIndex].TSubType != TokenSubTypeStart {
+		return -1
+	}
+	depth := 0
+	for i := startIndex; i < len(tokens); i++ {
+		switch tokens[i].TSubType {
+		case TokenSubTypeStart:
+			depth++
+		case TokenSubTypeStop:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Subtokens provides function to get the tokens of the balanced group opened
+// by the Start token at startIndex, from the Start through its matching Stop
+// inclusive. It returns false if the group is not balanced.
+// 返回指定开始标记所在分组的全部标记
+func Subtokens(tokens []Token, startIndex int) ([]Token, bool) {
+	stop := MatchingStop(tokens, startIndex)
+	if stop == -1 {
+		return nil, false
+	}
+	return tokens[startIndex : stop+1], true
+}
+
+// TopLevelComparison provides function to split a formula whose top level
+// contains exactly one logical comparison operator into the tokens on its
+// left, the operator and the tokens on its right. Comparison operators have
+// the lowest precedence, so the one at the top level is the root of the
+// expression. It returns false when there is no such single comparison.
+// 将顶层只包含一个比较操作符的公式拆分为左右两部分
+func (ps *Parser) TopLevelComparison() (left []Token, op Token, right []Token, ok bool) {
+	items := ps.items()
+	depth, index := 0, -1
+	for i, t := range items {
+		switch {
+		case t.TSubType == TokenSubTypeStart:
+			depth++
+		case t.TSubType == TokenSubTypeStop:
+			depth--
+		case depth == 0 && t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeLogical:
+			if index != -1 {
+				return nil, Token{}, nil, false
+			}
+			index = i
+		}
+	}
+	if index <= 0 || index == len(items)-1 {
+		return nil, Token{}, nil, false
+	}
+	return items[:index], items[index], items[index+1:], true
+}
+
+// IsNegated provides function to check if the whole parsed formula is a
+// negation: the root of its abstract syntax tree is a NOT call, a prefix "-",
+// or a subtraction from the number 1, as in =NOT(A1>0), =-(A1>0) and
+// =1-(A1>0). A sign binding only part of the formula, such as in =-A1+B1,
+// doesn't count.
+// 判断整个公式是否为取反运算
+func (ps *Parser) IsNegated() bool {
+	root, err := ps.ast()
+	if err != nil {
+		return false
+	}
+	t := root.Token
+	switch {
+	case t.TType == TokenTypeFunction && strings.EqualFold(t.TValue, "NOT") && len(root.Children) == 1:
+		return true
+	case t.TType == TokenTypeOperatorPrefix && t.TValue == "-":
+		return true
+	case t.TType == TokenTypeOperatorInfix && t.TValue == "-":
+		left := root.Children[0].Token
+		v, err := strconv.ParseFloat(left.TValue, 64)
+		return left.TType == TokenTypeOperand && left.TSubType == TokenSubTypeNumber && err == nil && v == 1
+	}
+	return false
+}
+
+// RootFunction provides function to get the name of the function when the
+// whole parsed formula, ignoring leading signs, is a single function call,
+// such as SUM for =SUM(A1:A3) or =-SUM(A1:A3). It returns false otherwise.
+// 公式为单个函数调用时返回该函数名
+func (ps *Parser) RootFunction() (string, bool) {
+	items := ps.items()
+	start := 0
+	for start < len(items) && (items[start].TType == TokenTypeOperatorPrefix || items[start].TType == TokenTypeNoop) {
+		start++
+	}
+	if start == len(items) || items[start].TType != TokenTypeFunction || isArrayToken(items[start]) ||
+		MatchingStop(items, start) != len(items)-1 {
+		return "", false
+	}
+	return items[start].TValue, true
+}
+
+// Comparisons provides function to get every comparison in the parsed
+// formula, including nested ones, in the order of their operators, with the
+// tokens of the operands the operator binds in the abstract syntax tree.
+// Parentheses around an operand are kept with it. It returns nil if the
+// formula is malformed.
+// 返回公式中所有的比较运算
+func (ps *Parser) Comparisons() []Comparison {
+	root, err := ps.ast()
+	if err != nil {
+		return nil
+	}
+	items := ps.items()
+	index := make(map[Token]int, len(items))
+	for i, t := range items {
+		index[t] = i
+	}
+	var comparisons []Comparison
+	// span gets the indexes of the first and last tokens of the subtree at
+	// n, or -1 for an omitted argument, and collects its comparisons
+	var span func(n *Node) (int, int)
+	span = func(n *Node) (int, int) {
+		from, ok := index[n.Token]
+		if !ok {
+			from = -1
+		}
+		to := from
+		if ok && n.Token.TSubType == TokenSubTypeStart {
+			to = MatchingStop(items, from)
+		}
+		var children [][2]int
+		for _, child := range n.Children {
+			f, t := span(child)
+			children = append(children, [2]int{f, t})
+			if f == -1 {
+				continue
+			}
+			if from == -1 || f < from {
+				from = f
+			}
+			if t > to {
+				to = t
+			}
+		}
+		if n.Token.TType == TokenTypeOperatorInfix && n.Token.TSubType == TokenSubTypeLogical &&
+			children[0][0] != -1 && children[1][0] != -1 {
+			left, right := children[0], children[1]
+			comparisons = append(comparisons, Comparison{
+				Left: items[left[0] : left[1]+1], Op: n.Token, Right: items[right[0] : right[1]+1],
+			})
+		}
+		for from > 0 && to+1 < len(items) && items[from-1].TType == TokenTypeSubexpression &&
+			items[from-1].TSubType == TokenSubTypeStart && MatchingStop(items, from-1) == to+1 {
+			from, to = from-1, to+1
+		}
+		return from, to
+	}
+	span(root)
+	sort.Slice(comparisons, func(i, j int) bool { return comparisons[i].Op.Start < comparisons[j].Op.Start })
+	return comparisons
+}
+
+// ConcatenationParts provides function to split a formula whose top level
+// is a chain of concatenations, such as ="Hello "&A1&"!", into the tokens of
+// each concatenated part. It returns false when the top level contains no
+// concatenation operator, or a comparison operator which binds looser.
+// 将顶层为连接运算的公式拆分为各个被连接的部分
+func (ps *Parser) ConcatenationParts() ([][]Token, bool) {
+	items := ps.items()
+	var parts [][]Token
+	depth, from := 0, 0
+	for i, t := range items {
+		switch {
+		case t.TSubType == TokenSubTypeStart:
+			depth++
+		case t.TSubType == TokenSubTypeStop:
+			depth--
+		case depth == 0 && t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeLogical:
+			return nil, false
+		case depth == 0 && t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeConcatenation:
+			parts = append(parts, items[from:i])
+			from = i + 1
+		}
+	}
+	if len(parts) == 0 {
+		return nil, false
+	}
+	return append(parts, items[from:]), true
+}
+
+// EnclosingFunctions provides function to get the names of the function
+// calls whose arguments contain the token at tokenIndex, outermost first.
+// Array constants are not reported.
+// 返回包含指定标记的所有函数名,由外到内排列
+func (ps *Parser) EnclosingFunctions(tokenIndex int) []string {
+	items := ps.items()
+	if tokenIndex < 0 || tokenIndex >= len(items) {
+		return nil
+	}
+	var stack []Token
+	for _, t := range items[:tokenIndex] {
+		switch t.TSubType {
+		case TokenSubTypeStart:
+			stack = append(stack, t)
+		case TokenSubTypeStop:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	var names []string
+	for _, t := range stack {
+		if t.TType == TokenTypeFunction && !isArrayToken(t) {
+			names = append(names, t.TValue)
+		}
+	}
+	return names
+}
+
+// ActiveArgument provides function to get the name of the innermost function
+// call enclosing the cursor at the given rune offset of the formula, and the
+// zero-based index of the argument the cursor is in, such as for argument
+// hints in an editor. Offsets index ps.Formula, which always starts with "=",
+// and a cursor at offset sits before the character at that offset. It
+// returns false if the cursor is not inside a function call.
+// 返回光标所在的最内层函数调用及其参数的索引
+func (ps *Parser) ActiveArgument(runeOffset int) (function string, argIndex int, ok bool) {
+	type frame struct {
+		token Token
+		args  int
+	}
+	var stack []frame
+	for _, t := range ps.items() {
+		if t.End > runeOffset {
+			break
+		}
+		switch {
+		case t.TSubType == TokenSubTypeStart:
+			stack = append(stack, frame{token: t})
+		case t.TSubType == TokenSubTypeStop:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case t.TType == TokenTypeArgument:
+			if len(stack) > 0 {
+				stack[len(stack)-1].args++
+			}
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if t := stack[i].token; t.TType == TokenTypeFunction && !isArrayToken(t) {
+			return t.TValue, stack[i].args, true
+		}
+	}
+	return "", 0, false
+}
+
+// ArgumentSeparatorOffsets provides function to get the rune offsets of the
+// argument separators at the top level of the outermost function call, such
+// as for inserting or removing arguments in an editor. Offsets index
+// ps.Formula, which always starts with "=". Separators of nested calls and
+// array constants are not reported.
+// 返回最外层函数调用中顶层参数分隔符的位置
+func (ps *Parser) ArgumentSeparatorOffsets() []int {
+	items := ps.items()
+	depth, call := 0, -1
+	var offsets []int
+	for i, t := range items {
+		switch {
+		case t.TSubType == TokenSubTypeStart:
+			if call < 0 && depth == 0 && t.TType == TokenTypeFunction && !isArrayToken(t) {
+				call = i
+			}
+			depth++
+		case t.TSubType == TokenSubTypeStop:
+			depth--
+			if call >= 0 && depth == 0 {
+				return offsets
+			}
+		case call >= 0 && depth == 1 && t.TType == TokenTypeArgument:
+			offsets = append(offsets, t.Start)
+		}
+	}
+	return offsets
+}
+
+// PrettyPrint provides function to pretty the parsed result with the indented
+// format.
+// 以缩进格式打印解析结果
+func (ps *Parser) PrettyPrint() string {
+	indent := 0
+	output := ""
+	for _, t := range ps.Tokens.Items {
+		if t.TSubType == TokenSubTypeStop {
+			indent--
+		}
+		for i := 0; i < indent; i++ {
+			output += "\t"
+		}
+		output += t.TValue + " <" + t.TType + "> <" + t.TSubType + ">" + "\n"
+		if t.TSubType == TokenSubTypeStart {
+			indent++
+		}
+	}
+	return output
+}
+
+// Render provides function to get formatted formula after parsed.
+// 解析好后格式化的公式
+func (ps *Parser) Render() string {
+	output := ""
+	for _, t := range ps.Tokens.Items {
+		if t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStart {
+			output += t.TValue + "("
+		} else if t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStop {
+			output += ")"
+		} else if t.TType == TokenTypeSubexpression && t.TSubType == TokenSubTypeStart {
+			output += "("
+		} else if t.TType == TokenTypeSubexpression && t.TSubType == TokenSubTypeStop {
+			output += ")"
+		} else if t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeText {
+			output += "\"" + t.TValue + "\""
+		} else if t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeIntersection {
+			output += " "
+		} else {
+			output += t.TValue
+		}
+	}
+	return output
+}
+
+// RenderedLen provides function to get the length in bytes of the formula
+// Render would produce, without building it.
+// 返回Render格式化后的公式的长度
+func (ps *Parser) RenderedLen() int {
+	n := 0
+	for _, t := range ps.Tokens.Items {
+		switch {
+		case t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStart:
+			n += len(t.TValue) + 1
+		case t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeText:
+			n += len(t.TValue) + 2
+		case t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeIntersection,
+			t.TSubType == TokenSubTypeStop, t.TType == TokenTypeSubexpression:
+			n++
+		default:
+			n += len(t.TValue)
+		}
+	}
+	return n
+}
+
+// RenderWithLocale provides function to get the formula after parsed, with a
+// leading "=", using the argument, array and decimal separators of the given
+// locale. Unlike Render, array constants are written back in braces.
+// 按照指定的区域设置格式化解析好的公式
+func (ps *Parser) RenderWithLocale(loc Locale) string {
+	return renderTokens(ps.Tokens.Items, loc)
+}
+
+// renderTokens provides a method to get the formula of the given token
+// stream, with a leading "=", using the separators of the given locale.
+// 按照指定的区域设置将标记流格式化为公式
+func renderTokens(tokens []Token, loc Locale) string {
+	return "=" + strings.Join(renderPieces(tokens, loc), "")
+}
+
+// renderPieces provides a method to get the formula text of each token of
+// the given token stream, using the separators of the given locale.
+// 按照指定的区域设置返回每个标记的公式文本
+func renderPieces(tokens []Token, loc Locale) []string {
+	pieces := make([]string, len(tokens))
+	var groups []string
+	for i, t := range tokens {
+		output := ""
+		switch {
+		case t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStart:
+			groups = append(groups, t.TValue)
+			switch t.TValue {
+			case "ARRAY":
+				output += "{"
+			case "ARRAYROW":
+			default:
+				output += t.TValue + "("
+			}
+		case t.TType == TokenTypeFunction && t.TSubType == TokenSubTypeStop:
+			name := ""
+			if len(groups) > 0 {
+				name, groups = groups[len(groups)-1], groups[:len(groups)-1]
+			}
+			switch name {
+			case "ARRAY":
+				output += "}"
+			case "ARRAYROW":
+			default:
+				output += ")"
+			}
+		case t.TType == TokenTypeSubexpression && t.TSubType == TokenSubTypeStart:
+			groups = append(groups, "")
+			output += "("
+		case t.TType == TokenTypeSubexpression && t.TSubType == TokenSubTypeStop:
+			if len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+			output += ")"
+		case t.TType == TokenTypeArgument:
+			group := ""
+			if len(groups) > 0 {
+				group = groups[len(groups)-1]
+			}
+			switch group {
+			case "ARRAY":
+				output += loc.ArrayRowSeparator
+			case "ARRAYROW":
+				output += loc.ArrayColumnSeparator
+			default:
+				output += loc.ArgumentSeparator
+			}
+		case t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeText:
+			output += "\"" + strings.Replace(t.TValue, "\"", "\"\"", -1) + "\""
+		case t.TType == TokenTypeOperand && (t.TSubType == TokenSubTypeNumber || t.TSubType == TokenSubTypePercent):
+			output += strings.Replace(t.TValue, ".", loc.DecimalSeparator, 1)
+		case t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeIntersection:
+			output += " "
+		case t.TType == TokenTypeOperatorInfix && t.TSubType == TokenSubTypeUnion:
+			output += loc.ArgumentSeparator
+		default:
+			output += t.TValue
+		}
+		pieces[i] = output
+	}
+	return pieces
+}
+
+// RenderSpaced provides function to get the formula after parsed, with a
+// leading "=", with a single space around each arithmetic, concatenation and
+// comparison infix operator, such as =A1 + B1 * 2. Reference operators and
+// prefix signs are kept tight, as spaces there would change the meaning.
+// 在中缀运算符两侧加上空格后格式化解析好的公式
+func (ps *Parser) RenderSpaced() string {
+	pieces := renderPieces(ps.Tokens.Items, LocaleUS)
+	for i, t := range ps.Tokens.Items {
+		if t.TType != TokenTypeOperatorInfix {
+			continue
+		}
+		switch t.TSubType {
+		case TokenSubTypeMath, TokenSubTypeConcatenation, TokenSubTypeLogical:
+			pieces[i] = " " + pieces[i] + " "
+		}
+	}
+	return "=" + strings.Join(pieces, "")
+}
+
+// RenderHTML provides function to get the formula after parsed, with a
+// leading "=", for embedding in HTML: each token is HTML-escaped and wrapped
+// in a span whose classes are the token's type and subtype, such as
+// <span class="Operand Range">A1</span>.
+// 以HTML格式返回解析好的公式,每个标记都包含在span元素中
+func (ps *Parser) RenderHTML() string {
+	output := "="
+	for i, piece := range renderPieces(ps.Tokens.Items, LocaleUS) {
+		if piece == "" {
+			continue
+		}
+		t := ps.Tokens.Items[i]
+		class := strings.TrimSpace(t.TType + " " + t.TSubType)
+		output += `<span class="` + html.EscapeString(class) + `">` + html.EscapeString(piece) + "</span>"
+	}
+	return output
+}
+
+// RenderWithRefMapper provides function to get the formula after parsed,
+// with a leading "=", with the value of every reference and name operand
+// replaced by the result of fn, such as for anonymizing formulas.
+// 使用指定的函数替换引用和名称后格式化解析好的公式
+func (ps *Parser) RenderWithRefMapper(fn func(ref string) string) string {
+	tokens := append([]Token(nil), ps.items()...)
+	for i, t := range tokens {
+		if isReferenceToken(t) || t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeName {
+			tokens[i].TValue = fn(t.TValue)
+		}
+	}
+	return renderTokens(tokens, LocaleUS)
+}
+
+// RenderWithErrorPlaceholder provides function to get the formula after
+// parsed, with a leading "=", with every error operand such as #REF!
+// rendered as placeholder, such as for safe display.
+// 使用占位符替换错误值后格式化解析好的公式
+func (ps *Parser) RenderWithErrorPlaceholder(placeholder string) string {
+	tokens := append([]Token(nil), ps.items()...)
+	for i, t := range tokens {
+		if t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeError {
+			tokens[i].TValue = placeholder
+		}
+	}
+	return renderTokens(tokens, LocaleUS)
+}
+
+// InlineName provides function to replace every operand referring to the
+// defined name with the tokenized definition, and get the resulting formula
+// with a leading "=". Names are matched case-insensitively. Definitions of
+// more than one token are wrapped in parentheses to preserve precedence.
+// 将公式中的定义名称替换为其定义的表达式
+func (ps *Parser) InlineName(name, definition string) (string, error) {
+	if name == "" {
+		return "", errors.New("name must not be empty")
+	}
+	def := ExcelParser()
+	defTokens := def.Parse(definition)
+	if len(defTokens) == 0 {
+		return "", fmt.Errorf("definition of %s is empty", name)
+	}
+	var tokens []Token
+	for _, t := range ps.items() {
+		if t.TType != TokenTypeOperand || (t.TSubType != TokenSubTypeName && t.TSubType != TokenSubTypeRange) || !strings.EqualFold(t.TValue, name) {
+			tokens = append(tokens, t)
+			continue
+		}
+		if len(defTokens) == 1 {
+			tokens = append(tokens, defTokens[0])
+			continue
+		}
+		tokens = append(tokens, fToken("", TokenTypeSubexpression, TokenSubTypeStart))
+		tokens = append(tokens, defTokens...)
+		tokens = append(tokens, fToken("", TokenTypeSubexpression, TokenSubTypeStop))
+	}
+	return renderTokens(tokens, LocaleUS), nil
+}
+
+// SwapReferences provides function to exchange every reference or name
+// operand equal to a with b and every one equal to b with a at the same
+// time, compared case-insensitively, and get the resulting formula with a
+// leading "=", such as =B1+A1*B1 for A1 and B1 in =A1+B1*A1.
+// 同时交换公式中的两个引用
+func (ps *Parser) SwapReferences(a, b string) (string, error) {
+	if a == "" || b == "" {
+		return "", errors.New("references must not be empty")
+	}
+	if strings.EqualFold(a, b) {
+		return "", fmt.Errorf("cannot swap %s with itself", a)
+	}
+	return ps.RenderWithRefMapper(func(ref string) string {
+		switch {
+		case strings.EqualFold(ref, a):
+			return b
+		case strings.EqualFold(ref, b):
+			return a
+		}
+		return ref
+	}), nil
+}
+
+// ValidateDelimiters provides function to check that the parentheses,
+// braces, brackets, double-quoted strings and single-quoted sheet names of
+// the formula are balanced and properly nested. The error describes the
+// first problem found, with its rune offset in ps.Formula.
+// 检查公式中的括号、引号等分隔符是否匹配
+func (ps *Parser) ValidateDelimiters() error {
+	closing := map[rune]rune{'(': ')', '{': '}', '[': ']'}
+	type open struct {
+		char   rune
+		offset int
+	}
+	var stack []open
+	f := []rune(ps.Formula)
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		inBracket := len(stack) > 0 && stack[len(stack)-1].char == '['
+		switch {
+		case (c == '"' || c == '\'') && !inBracket:
+			start := i
+			for i++; i < len(f) && (f[i] != c || (i+1 < len(f) && f[i+1] == c)); i++ {
+				if f[i] == c {
+					i++
+				}
+			}
+			if i >= len(f) {
+				return &SyntaxError{Offset: start, Message: fmt.Sprintf("unterminated %q", c)}
+			}
+		case c == '[' || (!inBracket && (c == '(' || c == '{')):
+			stack = append(stack, open{c, i})
+		case c == ']' || (!inBracket && (c == ')' || c == '}')):
+			if len(stack) == 0 || closing[stack[len(stack)-1].char] != c {
+				return &SyntaxError{Offset: i, Message: fmt.Sprintf("unexpected %q", c)}
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) > 0 {
+		top := stack[len(stack)-1]
+		return &SyntaxError{Offset: top.offset, Message: fmt.Sprintf("unclosed %q", top.char)}
+	}
+	return nil
+}
+
+// Lint provides function to run every lint check over the parsed formula and
+// get the potential problems found, in the order of the checks.
+// 对解析好的公式进行全部检查,返回可能存在的问题
+func (ps *Parser) Lint() []LintIssue {
+	var issues []LintIssue
+	for _, check := range []func() []LintIssue{
+		ps.ValidateReferences,
+		ps.lintWholeReferences,
+		ps.lintDuplicateOperators,
+		ps.lintBareFunctionNames,
+		ps.lintReferenceGrammar,
+		ps.lintDivisionByZero,
+		ps.lintMixedReferenceStyles,
+	} {
+		issues = append(issues, check()...)
+	}
+	return issues
+}
+
+// lintWholeReferences provides a method to find whole column and whole row
+// references, which make Excel evaluate over a million cells.
+// 查找整列和整行的引用
+func (ps *Parser) lintWholeReferences() []LintIssue {
+	var issues []LintIssue
+	for _, t := range ps.items() {
+		if !isReferenceToken(t) {
+			continue
+		}
+		switch ClassifyReference(t.TValue) {
+		case TokenSubTypeColumn:
+			issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("%s refers to whole columns", t.TValue)})
+		case TokenSubTypeRow:
+			issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("%s refers to whole rows", t.TValue)})
+		}
+	}
+	return issues
+}
+
+// lintBareFunctionNames provides a method to find the operands matching one
+// of KnownFunctions, such as SUM in =SUM+1, which name a function without
+// calling it.
+// 查找作为操作数使用的已知函数名
+func (ps *Parser) lintBareFunctionNames() []LintIssue {
+	var issues []LintIssue
+	for _, t := range ps.items() {
+		if (isReferenceToken(t) || t.TType == TokenTypeOperand && t.TSubType == TokenSubTypeName) && ps.isKnownFunction(t.TValue) {
+			issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("function name %s is used as an operand", t.TValue)})
+		}
+	}
+	return issues
+}
+
+// lintReferenceGrammar provides a method to find the reference operands
+// which are not a valid cell, range, column, row, structured reference or
+// name, optionally qualified by a sheet, such as 1!A1 from =Sheet 1!A1 where
+// the sheet name needs quotes.
+// 查找不符合引用语法的引用
+func (ps *Parser) lintReferenceGrammar() []LintIssue {
+	var issues []LintIssue
+	for _, t := range ps.items() {
+		if isReferenceToken(t) && !isValidReference(t.TValue, ps.Dialect) {
+			issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("%s is not a valid reference", t.TValue)})
+		}
+	}
+	return issues
+}
+
+// isValidReference provides a method to check if a reference operand value
+// is a cell, range, column, row, spilled range, structured reference or name,
+// optionally qualified by a quoted or unquoted sheet, sheet range or workbook.
+// Google Sheets also accepts open ended ranges such as A2:A and A2:2.
+// 判断引用是否符合引用语法
+func isValidReference(value string, d Dialect) bool {
+	body := value
+	if strings.HasPrefix(value, "'") {
+		closing := -1
+		for i := 1; i < len(value); i++ {
+			if value[i] != '\'' {
+				continue
+			}
+			if i+1 < len(value) && value[i+1] == '\'' {
+				i++
+				continue
+			}
+			closing = i
+			break
+		}
+		if closing == -1 || closing+1 >= len(value) || value[closing+1] != '!' {
+			return false
+		}
+		body = value[closing+2:]
+	} else if idx := strings.LastIndex(value, "!"); idx != -1 {
+		// 工作簿级别的名称和表格引用(如[1]!Table1[Col])没有工作表名
+		if sheet := workbookPattern.ReplaceAllString(value[:idx], ""); sheet != "" || sheet == value[:idx] {
+			for _, name := range strings.Split(sheet, ":") {
+				if !sheetNamePattern.MatchString(name) {
+					return false
+				}
+			}
+		}
+		body = value[idx+1:]
+	}
+	if d == DialectGoogleSheets && openRangePattern.MatchString(body) {
+		return true
+	}
+	return referencePattern.MatchString(strings.TrimSuffix(body, "#")) ||
+		namePattern.MatchString(body) || structuredPattern.MatchString(body)
+}
+
+// lintDivisionByZero provides a method to find the divisions whose divisor
+// is a constant expression evaluating to zero, such as =A1/0 or =A1/(1-1).
+// 查找除数为零的常量表达式的除法运算
+func (ps *Parser) lintDivisionByZero() []LintIssue {
+	root, err := ps.ast()
+	if err != nil {
+		return nil
+	}
+	var issues []LintIssue
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.Token.TType == TokenTypeOperatorInfix && n.Token.TValue == "/" && len(n.Children) == 2 {
+			if v, ok := constantValue(n.Children[1]); ok && v == 0 {
+				issues = append(issues, LintIssue{Token: n.Token, Message: fmt.Sprintf("division by zero at offset %d", n.Token.Start)})
+			}
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	return issues
+}
+
+// constantValue provides a method to evaluate a node made only of numbers and
+// arithmetic operators. It returns false for any other node, and for
+// divisions by zero.
+// 计算仅由数值和算术运算符组成的节点的值
+func constantValue(n *Node) (float64, bool) {
+	t := n.Token
+	if t.TType == TokenTypeOperand {
+		switch t.TSubType {
+		case TokenSubTypeNumber:
+			v, err := strconv.ParseFloat(t.TValue, 64)
+			return v, err == nil
+		case TokenSubTypePercent:
+			v, err := strconv.ParseFloat(strings.TrimSuffix(t.TValue, "%"), 64)
+			return v / 100, err == nil
+		}
+		return 0, false
+	}
+	var args []float64
+	for _, child := range n.Children {
+		v, ok := constantValue(child)
+		if !ok {
+			return 0, false
+		}
+		args = append(args, v)
+	}
+	switch {
+	case t.TType == TokenTypeOperatorPrefix && len(args) == 1:
+		return -args[0], true
+	case t.TType == TokenTypeOperatorPostfix && t.TValue == "%" && len(args) == 1:
+		return args[0] / 100, true
+	case t.TType == TokenTypeOperatorInfix && len(args) == 2:
+		switch t.TValue {
+		case "+":
+			return args[0] + args[1], true
+		case "-":
+			return args[0] - args[1], true
+		case "*":
+			return args[0] * args[1], true
+		case "/":
+			return args[0] / args[1], args[1] != 0
+		case "^":
+			return math.Pow(args[0], args[1]), true
+		}
+	}
+	return 0, false
+}
+
+// lintMixedReferenceStyles provides a method to find the references written
+// in a different style from the first reference of the formula, such as
+// R1C1 in =A1+R1C1. References valid in both styles, such as R1, are ignored.
+// 查找与公式中第一个引用的样式不同的引用
+func (ps *Parser) lintMixedReferenceStyles() []LintIssue {
+	var issues []LintIssue
+	first, firstR1C1 := "", false
+	for _, t := range ps.items() {
+		if !isReferenceToken(t) {
+			continue
+		}
+		_, a1 := parseReference(t.TValue)
+		r1c1 := classifyR1C1(t.TValue) != ""
+		if a1 == r1c1 {
+			continue
+		}
+		if first == "" {
+			first, firstR1C1 = t.TValue, r1c1
+			continue
+		}
+		if r1c1 != firstR1C1 {
+			style, other := "A1", "R1C1"
+			if r1c1 {
+				style, other = other, style
+			}
+			issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("%s is in %s style while %s is in %s style", t.TValue, style, first, other)})
+		}
+	}
+	return issues
+}
+
+// isKnownFunction provides a method to check if the value is one of
+// KnownFunctions, compared case-insensitively.
+// 判断是否为已知的函数名
+func (ps *Parser) isKnownFunction(value string) bool {
+	_, ok := ps.KnownFunctions[strings.ToUpper(value)]
+	return ok
+}
+
+// isFunctionName provides a method to check if the value, compared case
+// insensitively and without the leading "@" and the first of
+// FunctionPrefixes it starts with, is one of BuiltinFunctions or
+// KnownFunctions.
+// 判断是否为内置函数或已知函数的名称
+func (ps *Parser) isFunctionName(value string) bool {
+	value = strings.TrimLeft(value, "@")
+	for _, prefix := range FunctionPrefixes {
+		if len(value) > len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			value = value[len(prefix):]
+			break
+		}
+	}
+	_, ok := BuiltinFunctions[strings.ToUpper(value)]
+	return ok || ps.isKnownFunction(value)
+}
+
+// ValidateFunctionCalls provides function to find the operands in the parsed
+// formula which match one of KnownFunctions and are followed by an
+// intersection with another operand, such as SUM in =SUM A1:A3, which likely
+// miss the parentheses of a function call. Function names are matched case
+// insensitively.
+// 查找疑似缺少括号的函数调用
+func (ps *Parser) ValidateFunctionCalls() []LintIssue {
+	var issues []LintIssue
+	items := ps.items()
+	for i := 0; i+2 < len(items); i++ {
+		t := items[i]
+		if t.TType != TokenTypeOperand || (!isReferenceToken(t) && t.TSubType != TokenSubTypeName) {
+			continue
+		}
+		if !ps.isKnownFunction(t.TValue) {
+			continue
+		}
+		if next := items[i+1]; next.TType != TokenTypeOperatorInfix || next.TSubType != TokenSubTypeIntersection {
+			continue
+		}
+		if items[i+2].TType == TokenTypeOperand {
+			issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("%s may be missing the parentheses of a function call", t.TValue)})
+		}
+	}
+	return issues
+}
+
+// lintDuplicateOperators provides a method to find infix operators directly
+// followed by another infix operator, such as the second "*" in =A1**B1. A "-"
+// or "+" after an operator is a sign of the following operand and is not
+// reported: =A1+-B1 negates B1, and the unary plus in =A1++B1 is accepted by
+// Excel and dropped by the tokenizer, so neither is flagged.
+// 查找连续的中缀操作符
+func (ps *Parser) lintDuplicateOperators() []LintIssue {
+	var issues []LintIssue
+	items := ps.items()
+	for i := 1; i < len(items); i++ {
+		prev, t := items[i-1], items[i]
+		if prev.TType != TokenTypeOperatorInfix || t.TType != TokenTypeOperatorInfix ||
+			prev.TSubType == TokenSubTypeIntersection || t.TSubType == TokenSubTypeIntersection {
+			continue
+		}
+		issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("operator %s at offset %d follows operator %s", t.TValue, t.Start, prev.TValue)})
+	}
+	return issues
+}
+
+// ValidateReferences provides function to find the A1 style references in
+// the parsed formula which lie beyond the last column XFD or the last row
+// 1048576 of an Excel worksheet.
+// 检查公式中的引用是否超出工作表的范围
+func (ps *Parser) ValidateReferences() []LintIssue {
+	var issues []LintIssue
+	for _, t := range ps.items() {
+		if !isReferenceToken(t) {
+			continue
+		}
+		ref, ok := parseReference(t.TValue)
+		if !ok {
+			continue
+		}
+		for _, c := range ref.cells {
+			if c.col > maxColumns {
+				issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("column of %s is beyond %s", t.TValue, columnName(maxColumns))})
+				break
+			}
+			if c.row > maxRows {
+				issues = append(issues, LintIssue{Token: t, Message: fmt.Sprintf("row of %s is beyond %d", t.TValue, maxRows)})
+				break
+			}
+		}
+	}
+	return issues
+}
+
+// ValidateArrays provides function to check that every row of each array
+// constant in the parsed formula has the same number of elements, as Excel
+// rejects ragged arrays such as {1,2;3}. The error identifies the first
+// offending row, counted from 1.
+// 检查数组常量的每一行元素个数是否相同
+func (ps *Parser) ValidateArrays() error {
+	type frame struct {
+		name     string
+		elements int
+		nonEmpty bool
+		rows     []int
+	}
+	var stack []*frame
+	for _, t := range ps.items() {
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.name == "ARRAYROW" && t.TType != TokenTypeArgument {
+			top.nonEmpty = true
+		}
+		if t.TSubType == TokenSubTypeStart && (t.TType == TokenTypeFunction || t.TType == TokenTypeSubexpression) {
+			stack = append(stack, &frame{name: t.TValue})
+			continue
+		}
+		if t.TType == TokenTypeArgument && top != nil && top.name == "ARRAYROW" {
+			top.elements++
+			continue
+		}
+		if t.TSubType != TokenSubTypeStop || top == nil {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		switch top.name {
+		case "ARRAYROW":
+			count := 0
+			if top.nonEmpty {
+				count = top.elements + 1
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.rows = append(parent.rows, count)
+			}
+		case "ARRAY":
+			for i, count := range top.rows {
+				if count != top.rows[0] {
+					return fmt.Errorf("array row %d has %d elements, expected %d", i+1, count, top.rows[0])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isName provides a method to check if an operand value is a defined name,
+// optionally qualified by a sheet, rather than a cell reference.
+// 判断操作数是否为定义的名称
+func isName(value string) bool {
+	if idx := strings.LastIndex(value, "!"); idx != -1 {
+		value = value[idx+1:]
+	}
+	return namePattern.MatchString(value) && !referencePattern.MatchString(value)
+}
+
+// normalizeReference provides a method to get an A1 style reference with its
+// column letters in upper case, keeping the sheet as written. Other values
+// are returned unchanged.
+// 将A1格式引用的列名转换为大写,工作表名保持不变
+func normalizeReference(value string) string {
+	if _, ok := parseReference(value); !ok {
+		return value
+	}
+	idx := strings.LastIndex(value, "!") + 1
+	return value[:idx] + strings.ToUpper(value[idx:])
+}
+
+// refineRange provides a method to get the refined subtype of a reference
+// operand: Cell, Column, Row, or Range for everything else.
+// 返回引用操作数细分后的子类型
+func (ps *Parser) refineRange(value string) string {
+	subType := ClassifyReference(value)
+	if ps.R1C1 {
+		subType = classifyR1C1(value)
+	}
+	if subType != "" {
+		return subType
+	}
+	return TokenSubTypeRange
+}
+
+// classifyR1C1 provides a method to get the kind of an R1C1 style reference
+// operand value: Cell, Column for C1 or C1:C2, Row for R1 or R1:R2, or Range.
+// It returns an empty string if the value is not an R1C1 style reference.
+// 返回R1C1格式引用的类型
+func classifyR1C1(value string) string {
+	if idx := strings.LastIndex(value, "!"); idx != -1 {
+		value = value[idx+1:]
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return ""
+	}
+	var kind string
+	for _, part := range parts {
+		m := r1c1EndpointPattern.FindStringSubmatch(part)
+		if m == nil || part == "" {
+			return ""
+		}
+		k := TokenSubTypeCell
+		if m[1] == "" {
+			k = TokenSubTypeColumn
+		} else if m[2] == "" {
+			k = TokenSubTypeRow
+		}
+		if kind != "" && kind != k {
+			return ""
+		}
+		kind = k
+	}
+	if kind == TokenSubTypeCell && len(parts) == 2 {
+		return TokenSubTypeRange
+	}
+	return kind
+}
+
+// cellRef describes one endpoint of an A1 style reference: a cell, or the
+// column or row of a whole column or row reference.
+// 单元格引用的一个端点
+type cellRef struct {
+	col    int //列号,从1开始,为0时表示没有列
+	colAbs bool
+	row    int //行号,从1开始,为0时表示没有行
+	rowAbs bool
+}
+
+// String provides a method to get the A1 text of the endpoint.
+// 返回端点的A1格式文本
+func (c cellRef) String() string {
+	s := ""
+	if c.col > 0 {
+		if c.colAbs {
+			s += "$"
+		}
+		s += columnName(c.col)
+	}
+	if c.row > 0 {
+		if c.rowAbs {
+			s += "$"
+		}
+		s += strconv.Itoa(c.row)
+	}
+	return s
+}
+
+// reference describes an A1 style reference operand split into its sheet
+// prefix, one or two endpoints and the spilled range operator.
+// A1格式的引用,由工作表前缀、端点和溢出标记组成
+type reference struct {
+	sheet string //工作表前缀,不含感叹号
+	cells []cellRef
+	spill bool
+}
+
+// String provides a method to get the text of the reference.
+// 返回引用的文本
+func (r reference) String() string {
+	s := ""
+	if r.sheet != "" {
+		s = r.sheet + "!"
+	}
+	for i, c := range r.cells {
+		if i > 0 {
+			s += ":"
+		}
+		s += c.String()
+	}
+	if r.spill {
+		s += "#"
+	}
+	return s
+}
+
+// endpointPattern matches one endpoint of an A1 style reference.
+var endpointPattern = regexp.MustCompile(`^(?i)(\$?)([A-Z]{0,3})(\$?)([0-9]*)$`)
+
+// parseReference provides a method to split an A1 style reference operand
+// such as Sheet1!$A$1:B2 into its parts. Both endpoints of a range must be of
+// the same kind: cells, whole columns or whole rows.
+// 解析A1格式的引用
+func parseReference(value string) (reference, bool) {
+	var ref reference
+	if idx := strings.LastIndex(value, "!"); idx != -1 {
+		ref.sheet, value = value[:idx], value[idx+1:]
+	}
+	if strings.HasSuffix(value, "#") {
+		ref.spill, value = true, strings.TrimSuffix(value, "#")
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return ref, false
+	}
+	for _, part := range parts {
+		m := endpointPattern.FindStringSubmatch(part)
+		if m == nil || (m[2] == "" && m[4] == "") || (m[2] == "" && m[1] != "" && m[3] != "") || (m[4] == "" && m[3] != "") {
+			return ref, false
+		}
+		var c cellRef
+		if m[2] != "" {
+			c.col, c.colAbs = columnNumber(m[2]), m[1] == "$"
+		}
+		if m[4] != "" {
+			row, err := strconv.Atoi(m[4])
+			if err != nil || row == 0 {
+				return ref, false
+			}
+			c.row, c.rowAbs = row, m[3] == "$" || (m[2] == "" && m[1] == "$")
+		}
+		if len(ref.cells) == 1 && ((ref.cells[0].col > 0) != (c.col > 0) || (ref.cells[0].row > 0) != (c.row > 0)) {
+			return ref, false
+		}
+		ref.cells = append(ref.cells, c)
+	}
+	if len(ref.cells) == 1 && (ref.cells[0].col == 0 || ref.cells[0].row == 0) {
+		return ref, false
+	}
+	if ref.spill && (len(ref.cells) != 1) {
+		return ref, false
+	}
+	return ref, true
+}
+
+// columnNumber provides a method to convert column letters to the column
+// number, counted from 1.
+// 将列名转换为列号
+func columnNumber(name string) int {
+	n := 0
+	for _, c := range strings.ToUpper(name) {
+		n = n*26 + int(c-'A') + 1
+	}
+	return n
+}
+
+// columnName provides a method to convert a column number, counted from 1, to
+// its letters.
+// 将列号转换为列名
+func columnName(n int) string {
+	name := ""
+	for n > 0 {
+		n--
+		name = string(rune('A'+n%26)) + name
+		n /= 26
+	}
+	return name
+}
+
+// isReferenceToken provides a method to check if a token is an operand
+// subtyped as a reference: Range, or one of the refined Cell, Column and Row.
+// 判断标记是否为引用操作数
+func isReferenceToken(t Token) bool {
+	if t.TType != TokenTypeOperand {
+		return false
+	}
+	switch t.TSubType {
+	case TokenSubTypeRange, TokenSubTypeCell, TokenSubTypeColumn, TokenSubTypeRow:
+		return true
+	}
+	return false
+}
+
+// stripComment provides a method to truncate formula at the first occurrence
+// of prefix outside double-quoted strings, single-quoted sheet names and
+// brackets.
+// 去掉公式中从注释前缀开始的部分
+func stripComment(formula, prefix string) string {
+	var quote rune
+	brackets := 0
+	for i, c := range formula {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0 // a doubled quote reopens on the next character
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			brackets++
+		case c == ']' && brackets > 0:
+			brackets--
+		case brackets == 0 && strings.HasPrefix(formula[i:], prefix):
+			return formula[:i]
+		}
+	}
+	return formula
+}
+
+// quoteSheetName provides a method to quote a sheet name for use in a
+// reference, if it is not a plain identifier or could be mistaken for a
+// reference.
+// 在需要时为工作表名加上单引号
+func quoteSheetName(name string) string {
+	if sheetNamePattern.MatchString(name) && ClassifyReference(name) == "" {
+		return name
+	}
+	return "'" + strings.Replace(name, "'", "''", -1) + "'"
+}
+
+// isSpillAnchor provides a method to check if an accumulated token is a
+// single cell reference, optionally qualified by a sheet, which the spilled
+// range operator "#" can follow.
+// 判断标记是否为可以跟随溢出操作符的单元格引用
+func isSpillAnchor(value string) bool {
+	if idx := strings.LastIndex(value, "!"); idx != -1 {
+		if idx == 0 {
+			return false
+		}
+		value = value[idx+1:]
+	}
+	return cellPattern.MatchString(value)
+}
+
+// isArrayToken provides a method to check if a token is one of the ARRAY or
+// ARRAYROW tokens marking an array constant.
+// 判断标记是否为数组常量的开始标记
+func isArrayToken(t Token) bool {
+	return t.TType == TokenTypeFunction && (t.TValue == "ARRAY" || t.TValue == "ARRAYROW")
 }
 
 // inStrSlice provides a method to check if an element is present in an array,
@@ -0,0 +1,20 @@
+//go:build go1.23
+// +build go1.23
+
+package efp
+
+import "iter"
+
+// Iter provides function to parse formula and get the token stream as a
+// sequence, in the same order as Parse returns it.
+// 解析公式字符串,并以迭代序列的形式返回标记
+func (ps *Parser) Iter(formula string) iter.Seq[Token] {
+	tokens := ps.Parse(formula)
+	return func(yield func(Token) bool) {
+		for _, t := range tokens {
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build go1.23
+// +build go1.23
+
+package efp
+
+import "testing"
+
+func TestIter(t *testing.T) {
+	formula := `=IF(SUM(A1:A3)>0,"yes",{1,2})`
+	p := ExcelParser()
+	var got []Token
+	for tk := range p.Iter(formula) {
+		got = append(got, tk)
+	}
+	want := ExcelParser()
+	assertTokens(t, formula, got, want.Parse(formula))
+
+	var first []Token
+	for tk := range p.Iter(formula) {
+		first = append(first, tk)
+		break
+	}
+	if len(first) != 1 || first[0].TValue != "IF" {
+		t.Errorf("Iter() with break = %v, want the IF token", first)
+	}
+}
@@ -1,6 +1,8 @@
 package efp
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -139,3 +141,2339 @@ func TestContainsAny(t *testing.T) {
 		t.Log(strings.ContainsAny(f, "<>="))
 	}
 }
+
+func TestSetFunctionRewriter(t *testing.T) {
+	p := ExcelParser()
+	p.SetFunctionRewriter(func(name string) string {
+		if name == "XLOOKUP" {
+			return "LOOKUP"
+		}
+		return name
+	})
+	p.Parse(`=XLOOKUP(A1,B:B,C:C)+@SUM(D1)`)
+	if got, want := p.Render(), `LOOKUP(A1,B:B,C:C)+SUM(D1)`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+// assertTokens compares the value, type and subtype of two token streams.
+func assertTokens(t *testing.T, formula string, got, want []Token) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d tokens %v, want %d tokens %v", formula, len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i].TValue != want[i].TValue || got[i].TType != want[i].TType || got[i].TSubType != want[i].TSubType {
+			t.Errorf("%s: token %d = %v, want %v", formula, i, got[i], want[i])
+		}
+	}
+}
+
+func TestCommaClassification(t *testing.T) {
+	p := ExcelParser()
+	f := `=SUM((A1,B1),C1)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStart),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeOperatorInfix, TokenSubTypeUnion),
+		fToken("B1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStop),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("C1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+
+	p = ExcelParser()
+	f = `=SUM(((A1,B1),MAX(C1,D1)),E1)`
+	var commas []string
+	for _, tk := range p.Parse(f) {
+		if tk.TValue == "," {
+			commas = append(commas, tk.TType)
+		}
+	}
+	want := []string{TokenTypeOperatorInfix, TokenTypeOperatorInfix, TokenTypeArgument, TokenTypeArgument}
+	if strings.Join(commas, " ") != strings.Join(want, " ") {
+		t.Errorf("%s: comma types = %v, want %v", f, commas, want)
+	}
+}
+
+func TestRenderWithLocale(t *testing.T) {
+	for _, c := range []struct {
+		formula string
+		loc     Locale
+		want    string
+	}{
+		{`=SUM(A1,A2)`, LocaleDE, `=SUM(A1;A2)`},
+		{`=ROUND(1.5,0)*{1,2;3,4}`, LocaleDE, `=ROUND(1,5;0)*{1\2;3\4}`},
+		{`=ROUND(1.5,0)*{1,2;3,4}`, LocaleUS, `=ROUND(1.5,0)*{1,2;3,4}`},
+		{`=IF(A1,"a""b",(B1,C1))`, LocaleUS, `=IF(A1,"a""b",(B1,C1))`},
+	} {
+		p := ExcelParser()
+		p.Parse(c.formula)
+		if got := p.RenderWithLocale(c.loc); got != c.want {
+			t.Errorf("RenderWithLocale(%s) = %q, want %q", c.formula, got, c.want)
+		}
+	}
+}
+
+func TestValidateArrays(t *testing.T) {
+	for _, c := range []struct {
+		formula string
+		err     string
+	}{
+		{`={1,2;3,4}`, ""},
+		{`=SUM({1,2,3})+{"a";"b"}`, ""},
+		{`={1,2;3}`, "array row 2 has 1 elements, expected 2"},
+		{`=SUM({1;2})*{1,2;3,4;5,6,7}`, "array row 3 has 3 elements, expected 2"},
+	} {
+		p := ExcelParser()
+		p.Parse(c.formula)
+		err := p.ValidateArrays()
+		if c.err == "" && err != nil {
+			t.Errorf("ValidateArrays(%s) = %v, want nil", c.formula, err)
+		}
+		if c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("ValidateArrays(%s) = %v, want %q", c.formula, err, c.err)
+		}
+	}
+}
+
+func TestTokenAt(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=IF(SUM(A1:A3) > 0,1,0)`)
+	for _, c := range []struct {
+		offset int
+		value  string
+		ok     bool
+	}{
+		{2, "IF", true},
+		{5, "SUM", true},
+		{10, "A1:A3", true},
+		{14, "", false},
+		{0, "", false},
+		{100, "", false},
+	} {
+		tk, ok := p.TokenAt(c.offset)
+		if ok != c.ok || tk.TValue != c.value {
+			t.Errorf("TokenAt(%d) = %v, %v, want %q, %v", c.offset, tk, ok, c.value, c.ok)
+		}
+	}
+}
+
+func TestFunctionPrefixes(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=_xlfn.XLOOKUP(A1,B:B,C:C)`:        `XLOOKUP(A1,B:B,C:C)`,
+		`=_xlfn._xlws.FILTER(A:A,B:B)`:      `FILTER(A:A,B:B)`,
+		`=_xll.MYADDIN(A1)+@_xlfn.CONCAT()`: `MYADDIN(A1)+CONCAT()`,
+		`=_xlud.MYUDF(A1)`:                  `_xlud.MYUDF(A1)`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.Render(); got != want {
+			t.Errorf("Render(%s) = %q, want %q", formula, got, want)
+		}
+	}
+
+	defer func(prefixes []string) { FunctionPrefixes = prefixes }(FunctionPrefixes)
+	FunctionPrefixes = []string{"_xlud."}
+	p := ExcelParser()
+	p.Parse(`=_xlud.MYUDF(A1)+_xlfn.CONCAT()`)
+	if got, want := p.Render(), `MYUDF(A1)+_xlfn.CONCAT()`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestHasSubexpression(t *testing.T) {
+	for formula, want := range map[string]bool{
+		`=(1+2)*3`:        true,
+		`=SUM((A1,B1))`:   true,
+		`=SUM(A1)`:        false,
+		`={1,2}+MAX(1,2)`: false,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.HasSubexpression(); got != want {
+			t.Errorf("HasSubexpression(%s) = %v, want %v", formula, got, want)
+		}
+	}
+}
+
+func TestEnclosingFunctions(t *testing.T) {
+	p := ExcelParser()
+	tokens := p.Parse(`=IF(SUM(A1:A3)>0,1,{1,MAX(2)})`)
+	for i, tk := range tokens {
+		var want []string
+		switch tk.TValue {
+		case "A1:A3":
+			want = []string{"IF", "SUM"}
+		case "IF":
+			want = nil
+		case "2":
+			want = []string{"IF", "MAX"}
+		default:
+			continue
+		}
+		if got := p.EnclosingFunctions(i); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("EnclosingFunctions(%d) = %v, want %v", i, got, want)
+		}
+	}
+	if got := p.EnclosingFunctions(len(tokens)); got != nil {
+		t.Errorf("EnclosingFunctions(%d) = %v, want nil", len(tokens), got)
+	}
+	if got := MatchingStop(tokens, 1); got != 3 {
+		t.Errorf("MatchingStop(1) = %d, want 3", got)
+	}
+	if got := MatchingStop(tokens, 2); got != -1 {
+		t.Errorf("MatchingStop(2) = %d, want -1", got)
+	}
+}
+
+func TestParseCheckedStrictEquals(t *testing.T) {
+	p := ExcelParser()
+	tokens, err := p.ParseChecked(`1+2`)
+	if err != nil {
+		t.Fatalf("ParseChecked(1+2) error: %v", err)
+	}
+	assertTokens(t, `1+2`, tokens, []Token{
+		fToken("1", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("2", TokenTypeOperand, TokenSubTypeNumber),
+	})
+
+	p.StrictEquals = true
+	if _, err = p.ParseChecked(`1+2`); err == nil || err.Error() != `formula must begin with "="` {
+		t.Errorf("ParseChecked(1+2) error = %v, want missing \"=\" error", err)
+	}
+	tokens, err = p.ParseChecked(` =1+2`)
+	if err != nil {
+		t.Fatalf("ParseChecked(=1+2) error: %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Errorf("ParseChecked(=1+2) = %v, want 3 tokens", tokens)
+	}
+}
+
+func TestDetectNames(t *testing.T) {
+	p := ExcelParser()
+	p.DetectNames = true
+	f := `=Region Product`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("Region", TokenTypeOperand, TokenSubTypeName),
+		fToken("", TokenTypeOperatorInfix, TokenSubTypeIntersection),
+		fToken("Product", TokenTypeOperand, TokenSubTypeName),
+	})
+	for _, c := range []struct {
+		value   string
+		subType string
+	}{
+		{"A1", TokenSubTypeRange},
+		{"$A$1:B2", TokenSubTypeRange},
+		{"Sheet1!A:A", TokenSubTypeRange},
+		{"1:1", TokenSubTypeRange},
+		{"R[1]C2", TokenSubTypeRange},
+		{"TaxRate", TokenSubTypeName},
+		{"Sheet1!Local_Name", TokenSubTypeName},
+		{"_Total.2020", TokenSubTypeName},
+	} {
+		tokens := p.Parse("=" + c.value)
+		if len(tokens) != 1 || tokens[0].TSubType != c.subType {
+			t.Errorf("Parse(=%s) = %v, want subtype %s", c.value, tokens, c.subType)
+		}
+	}
+
+	p.DetectNames = false
+	if tokens := p.Parse(`=TaxRate`); tokens[0].TSubType != TokenSubTypeRange {
+		t.Errorf("Parse(=TaxRate) = %v, want subtype Range", tokens)
+	}
+}
+
+func TestInlineName(t *testing.T) {
+	for _, c := range []struct {
+		formula, name, definition, want string
+	}{
+		{`=Price*TaxRate`, "TaxRate", "0.2", `=Price*0.2`},
+		{`=Price*taxrate+TaxRate2`, "TaxRate", "=1+0.2", `=Price*(1+0.2)+TaxRate2`},
+		{`=SUM(Sales)`, "sales", "Sheet1!A1:A10", `=SUM(Sheet1!A1:A10)`},
+	} {
+		p := ExcelParser()
+		p.DetectNames = true
+		p.Parse(c.formula)
+		got, err := p.InlineName(c.name, c.definition)
+		if err != nil || got != c.want {
+			t.Errorf("InlineName(%s, %s) on %s = %q, %v, want %q", c.name, c.definition, c.formula, got, err, c.want)
+		}
+	}
+	p := ExcelParser()
+	p.Parse(`=Price*TaxRate`)
+	if _, err := p.InlineName("TaxRate", ""); err == nil {
+		t.Error("InlineName with empty definition: want error")
+	}
+	if _, err := p.InlineName("", "0.2"); err == nil {
+		t.Error("InlineName with empty name: want error")
+	}
+}
+
+func TestSpill(t *testing.T) {
+	p := ExcelParser()
+	f := `=A1#`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("A1#", TokenTypeOperand, TokenSubTypeRange),
+	})
+	f = `=SUM($B$2#)*2`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("$B$2#", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken("*", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("2", TokenTypeOperand, TokenSubTypeNumber),
+	})
+
+	p.SpillAsOperator = true
+	f = `=A1#`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("#", TokenTypeOperatorPostfix, TokenSubTypeSpill),
+	})
+	if got := p.Render(); got != "A1#" {
+		t.Errorf("Render() = %q, want %q", got, "A1#")
+	}
+	f = `=A1+#N/A`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("#N/A", TokenTypeOperand, TokenSubTypeError),
+	})
+	for _, spillAsOperator := range []bool{false, true} {
+		p.SpillAsOperator = spillAsOperator
+		f = `=B2#N/A`
+		assertTokens(t, f, p.Parse(f), []Token{
+			fToken("B2", TokenTypeUnknown, ""),
+			fToken("#N/A", TokenTypeOperand, TokenSubTypeError),
+		})
+		f = `=A1#REF!`
+		assertTokens(t, f, p.Parse(f), []Token{
+			fToken("A1", TokenTypeUnknown, ""),
+			fToken("#REF!", TokenTypeOperand, TokenSubTypeError),
+		})
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{`=A1+B1`, `= A1 + B1`, true},
+		{`=A1+B1`, `=A1+B1 `, true},
+		{`=sum(a1:a3)`, `SUM(A1:A3)`, true},
+		{`=A1+B1`, `=A1+B2`, false},
+		{`="a"&A1`, `="A"&A1`, false},
+		{`=SUM(A1:A3 B2)`, `=SUM(A1:A3,B2)`, false},
+	} {
+		if got := Equivalent(c.a, c.b); got != c.want {
+			t.Errorf("Equivalent(%s, %s) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestRefineRanges(t *testing.T) {
+	p := ExcelParser()
+	p.RefineRanges = true
+	for value, want := range map[string]string{
+		"A1":              TokenSubTypeCell,
+		"$B$2":            TokenSubTypeCell,
+		"Sheet1!C3":       TokenSubTypeCell,
+		"R[-1]C2":         TokenSubTypeCell,
+		"A1:B2":           TokenSubTypeRange,
+		"Sheet1!$A$1:$B2": TokenSubTypeRange,
+		"A:A":             TokenSubTypeColumn,
+		"$A:$C":           TokenSubTypeColumn,
+		"1:1":             TokenSubTypeRow,
+		"Sheet1!$2:$5":    TokenSubTypeRow,
+		"A1#":             TokenSubTypeRange,
+	} {
+		if tokens := p.Parse("=" + value); len(tokens) != 1 || tokens[0].TSubType != want {
+			t.Errorf("Parse(=%s) = %v, want subtype %s", value, tokens, want)
+		}
+	}
+	p.RefineRanges = false
+	if tokens := p.Parse(`=A1`); tokens[0].TSubType != TokenSubTypeRange {
+		t.Errorf("Parse(=A1) = %v, want subtype Range", tokens)
+	}
+}
+
+func BenchmarkParseErrors(b *testing.B) {
+	formula := `=IF(ISERROR(A1),#N/A,IFERROR(B1/C1,#DIV/0!))&{#NULL!,#VALUE!;#REF!,#NAME?}+#NUM!`
+	formula += strings.Repeat(`+IF(A1=#N/A,#REF!,#VALUE!)`, 50)
+	for i := 0; i < b.N; i++ {
+		p := ExcelParser()
+		p.Parse(formula)
+	}
+}
+
+func BenchmarkParseLargeArray(b *testing.B) {
+	elements := make([]string, 10000)
+	for i := range elements {
+		elements[i] = strconv.Itoa(i)
+	}
+	formula := "={" + strings.Join(elements, ",") + "}"
+	for i := 0; i < b.N; i++ {
+		p := ExcelParser()
+		p.Parse(formula)
+	}
+}
+
+func TestParameterNames(t *testing.T) {
+	p := ExcelParser()
+	f := `=LAMBDA(x,y,x+y)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("LAMBDA", TokenTypeFunction, TokenSubTypeStart),
+		fToken("x", TokenTypeOperand, TokenSubTypeName),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("y", TokenTypeOperand, TokenSubTypeName),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("x", TokenTypeOperand, TokenSubTypeRange),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("y", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	f = `=LET(a,1,b,A1*2,a+b)`
+	var subTypes []string
+	for _, tk := range p.Parse(f) {
+		if tk.TType == TokenTypeOperand {
+			subTypes = append(subTypes, tk.TValue+":"+tk.TSubType)
+		}
+	}
+	want := "a:Name 1:Number b:Name A1:Range 2:Number a:Range b:Range"
+	if got := strings.Join(subTypes, " "); got != want {
+		t.Errorf("%s: operands = %s, want %s", f, got, want)
+	}
+	p.RefineRanges = true
+	f = `=LET(x1,A1,LAMBDA(c,x1*c))`
+	subTypes = nil
+	for _, tk := range p.Parse(f) {
+		if tk.TType == TokenTypeOperand {
+			subTypes = append(subTypes, tk.TValue+":"+tk.TSubType)
+		}
+	}
+	want = "x1:Name A1:Cell c:Name x1:Cell c:Range"
+	if got := strings.Join(subTypes, " "); got != want {
+		t.Errorf("%s with RefineRanges: operands = %s, want %s", f, got, want)
+	}
+}
+
+func TestIsConstant(t *testing.T) {
+	for formula, want := range map[string]bool{
+		`=5`:      true,
+		`="text"`: true,
+		`=TRUE`:   true,
+		`=#N/A`:   true,
+		`=A1`:     false,
+		`=1+1`:    false,
+		`=-5`:     false,
+		`=NOW()`:  false,
+		`={1}`:    false,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.IsConstant(); got != want {
+			t.Errorf("IsConstant(%s) = %v, want %v", formula, got, want)
+		}
+	}
+}
+
+func TestParseCheckedPostfix(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=5%`:       "",
+		`=(A1+B1)%`: "",
+		`=5%%`:      "",
+		`=%5`:       `unexpected "%" at offset 1`,
+		`=SUM(%A1)`: `unexpected "%" at offset 5`,
+	} {
+		p := ExcelParser()
+		_, err := p.ParseChecked(formula)
+		if (err == nil && want != "") || (err != nil && err.Error() != want) {
+			t.Errorf("ParseChecked(%s) error = %v, want %q", formula, err, want)
+		}
+	}
+}
+
+func TestSubtokens(t *testing.T) {
+	p := ExcelParser()
+	tokens := p.Parse(`=IF(SUM(A1:A3)>0,1,0)`)
+	sub, ok := Subtokens(tokens, 1)
+	if !ok {
+		t.Fatal("Subtokens(1) not balanced")
+	}
+	assertTokens(t, "SUM(A1:A3)", sub, []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A1:A3", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if sub, ok = Subtokens(tokens, 0); !ok || len(sub) != len(tokens) {
+		t.Errorf("Subtokens(0) = %v, %v, want the whole formula", sub, ok)
+	}
+	if _, ok = Subtokens(tokens, 2); ok {
+		t.Error("Subtokens(2) on an operand: want false")
+	}
+	if _, ok = Subtokens(p.Parse(`=SUM(A1`), 0); ok {
+		t.Error("Subtokens(0) on an unclosed call: want false")
+	}
+}
+
+func TestTopLevelComparison(t *testing.T) {
+	for _, c := range []struct {
+		formula, left, op, right string
+		ok                       bool
+	}{
+		{`=A1>5`, "A1", ">", "5", true},
+		{`=SUM(A1:A3)<=AVERAGE(B:B)`, "SUM A1:A3 ", "<=", "AVERAGE B:B ", true},
+		{`=IF(A1>5,1,0)`, "", "", "", false},
+		{`=A1=B1=C1`, "", "", "", false},
+		{`=A1+1`, "", "", "", false},
+	} {
+		p := ExcelParser()
+		p.Parse(c.formula)
+		left, op, right, ok := p.TopLevelComparison()
+		join := func(tokens []Token) string {
+			var values []string
+			for _, tk := range tokens {
+				values = append(values, tk.TValue)
+			}
+			return strings.Join(values, " ")
+		}
+		if ok != c.ok || join(left) != c.left || op.TValue != c.op || join(right) != c.right {
+			t.Errorf("TopLevelComparison(%s) = %q, %q, %q, %v", c.formula, join(left), op.TValue, join(right), ok)
+		}
+	}
+}
+
+func TestQualifiedAbsoluteReferences(t *testing.T) {
+	p := ExcelParser()
+	for _, f := range []string{`=Sheet1!$A$1:$B$2`, `='My Sheet'!$A$1`, `='It''s'!$A$1:$B$2`, `=[data.xls]sheet1!$A$1`} {
+		assertTokens(t, f, p.Parse(f), []Token{fToken(f[1:], TokenTypeOperand, TokenSubTypeRange)})
+		if got := p.Render(); got != f[1:] {
+			t.Errorf("Render(%s) = %q", f, got)
+		}
+	}
+
+	for value, want := range map[string]string{
+		`Sheet1!$A$1:$B$2`: TokenSubTypeRange,
+		`'My Sheet'!$A$1`:  TokenSubTypeCell,
+		`'My Sheet'!$A:$A`: TokenSubTypeColumn,
+		`Sheet1!1:$3`:      TokenSubTypeRow,
+		`R1C1`:             TokenSubTypeCell,
+		`TaxRate`:          "",
+		`A1:1`:             "",
+	} {
+		if got := ClassifyReference(value); got != want {
+			t.Errorf("ClassifyReference(%s) = %q, want %q", value, got, want)
+		}
+	}
+
+	for _, c := range []struct {
+		formula, want string
+	}{
+		{`=Sheet1!$A$1:$B$2`, `=Sheet1!$A$1:$B$2`},
+		{`='My Sheet'!$A$1+'My Sheet'!A1`, `='My Sheet'!$A$1+'My Sheet'!C2`},
+		{`=SUM(Sheet1!$A1:B$2,A:A,$1:1,"A1")`, `=SUM(Sheet1!$A2:D$2,C:C,$1:2,"A1")`},
+	} {
+		p.Parse(c.formula)
+		if got, err := p.ShiftReferences(1, 2); err != nil || got != c.want {
+			t.Errorf("ShiftReferences(%s) = %q, %v, want %q", c.formula, got, err, c.want)
+		}
+	}
+	p.Parse(`=A1`)
+	if _, err := p.ShiftReferences(-1, 0); err == nil {
+		t.Error("ShiftReferences(-1, 0) on A1: want error")
+	}
+}
+
+func TestToRPN(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=1+2*3`:            "1 2 3 * +",
+		`=(1+2)*3`:          "1 2 + 3 *",
+		`=1-2-3`:            "1 2 - 3 -",
+		`=-2^2`:             "2 - 2 ^",
+		`=2^-1`:             "2 1 - ^",
+		`=-5%`:              "5 - %",
+		`=A1&B1=C1`:         "A1 B1 & C1 =",
+		`=SUM(A1,A2)`:       "A1 A2 2 SUM",
+		`=IF(A1>0,MAX(),2)`: "A1 0 > 0 MAX 2 3 IF",
+		`=SUM(A1:A3 B2)*2`:  "A1:A3 B2  1 SUM 2 *",
+		`=2^3^2`:            "2 3 ^ 2 ^",
+		`=IF(A1,,)`:         "A1   3 IF",
+		`=SUM(,A1)`:         " A1 2 SUM",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		rpn, err := p.ToRPN()
+		if err != nil {
+			t.Errorf("ToRPN(%s) error: %v", formula, err)
+			continue
+		}
+		var values []string
+		for _, tk := range rpn {
+			values = append(values, tk.TValue)
+		}
+		if got := strings.Join(values, " "); got != want {
+			t.Errorf("ToRPN(%s) = %q, want %q", formula, got, want)
+		}
+	}
+	for _, formula := range []string{`=SUM(A1`, `=SUM())`, `=(1,2`} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if _, err := p.ToRPN(); err == nil {
+			t.Errorf("ToRPN(%s): want error", formula)
+		}
+	}
+}
+
+func TestDeprecatedFunctions(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=CONCAT(A1,concatenate(B1,C1),STDEV(D:D))`)
+	got := p.DeprecatedFunctions(map[string]string{"CONCATENATE": "CONCAT", "STDEV": "STDEV.S", "RANK": "RANK.EQ"})
+	want := map[string]string{"concatenate": "CONCAT", "STDEV": "STDEV.S"}
+	if len(got) != len(want) {
+		t.Fatalf("DeprecatedFunctions() = %v, want %v", got, want)
+	}
+	for name, replacement := range want {
+		if got[name] != replacement {
+			t.Errorf("DeprecatedFunctions()[%s] = %q, want %q", name, got[name], replacement)
+		}
+	}
+}
+
+func TestValidateDelimiters(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=IF(A1="a""(",{1,2},'It''s [x]'!B1)`: "",
+		`=SUM(Table1[[#Headers],[Col]])`:      "",
+		`=SUM({1,2)`:                          `unexpected ')' at offset 9`,
+		`=SUM({1,2}`:                          `unclosed '(' at offset 4`,
+		`=[data.xls sheet1!A1`:                `unclosed '[' at offset 1`,
+		`=A1&"abc`:                            `unterminated '"' at offset 4`,
+		`='Sheet 1!A1`:                        `unterminated '\'' at offset 1`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		err := p.ValidateDelimiters()
+		if (err == nil && want != "") || (err != nil && err.Error() != want) {
+			t.Errorf("ValidateDelimiters(%s) = %v, want %q", formula, err, want)
+		}
+	}
+}
+
+func TestValidateReferences(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=A1`:                      "",
+		`=SUM(XFD1048576,A:XFD)`:   "",
+		`=XFE1`:                    "column of XFE1 is beyond XFD",
+		`=A1048577`:                "row of A1048577 is beyond 1048576",
+		`=SUM(Sheet1!A1:B1048577)`: "row of Sheet1!A1:B1048577 is beyond 1048576",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		issues := p.ValidateReferences()
+		if want == "" {
+			if len(issues) != 0 {
+				t.Errorf("ValidateReferences(%s) = %v, want none", formula, issues)
+			}
+			continue
+		}
+		if len(issues) != 1 || issues[0].Message != want {
+			t.Errorf("ValidateReferences(%s) = %v, want %q", formula, issues, want)
+		}
+	}
+}
+
+func TestTextLiterals(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`="a"&"b"&IF(A1,"yes","say ""no""")`)
+	got := p.TextLiterals()
+	want := []string{"a", "b", "yes", `say "no"`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("TextLiterals() = %q, want %q", got, want)
+	}
+}
+
+func TestFormulaMarker(t *testing.T) {
+	comparison := []Token{
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("=", TokenTypeOperatorInfix, TokenSubTypeLogical),
+		fToken("B1", TokenTypeOperand, TokenSubTypeRange),
+	}
+	p := ExcelParser()
+	assertTokens(t, `=A1=B1`, p.Parse(`=A1=B1`), comparison)
+	assertTokens(t, `A1=B1`, p.Parse(`A1=B1`), comparison)
+	assertTokens(t, `==A1`, p.Parse(`==A1`), []Token{
+		fToken("=", TokenTypeOperatorInfix, TokenSubTypeLogical),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+	})
+}
+
+func TestFingerprintCaseSensitive(t *testing.T) {
+	upper, lower := ExcelParser(), ExcelParser()
+	upper.Parse(`=SUM(A1)`)
+	lower.Parse(`=sum(A1)`)
+	if upper.Fingerprint() != lower.Fingerprint() {
+		t.Error("Fingerprint() differs between =SUM(A1) and =sum(A1)")
+	}
+	if upper.FingerprintCaseSensitive() == lower.FingerprintCaseSensitive() {
+		t.Error("FingerprintCaseSensitive() matches between =SUM(A1) and =sum(A1)")
+	}
+	lower.Parse(` =SUM( A1 )`)
+	if upper.FingerprintCaseSensitive() != lower.FingerprintCaseSensitive() {
+		t.Error("FingerprintCaseSensitive() differs between =SUM(A1) and =SUM( A1 )")
+	}
+}
+
+func TestCommentPrefix(t *testing.T) {
+	p := ExcelParser()
+	p.CommentPrefix = "//"
+	for formula, want := range map[string]string{
+		`=A1+B1 // note`:                       `A1+B1`,
+		`=A1&"http://x"//url`:                  `A1&"http://x"`,
+		`='[http://x/a.xlsx]S'!A1 // linked`:   `'[http://x/a.xlsx]S'!A1`,
+		`=[http://x/a.xlsx]S!A1`:               `[http://x/a.xlsx]S!A1`,
+		`=IF(A1="a""//b",1,0)// quoted quotes`: `IF(A1="a""//b",1,0)`,
+	} {
+		p.Parse(formula)
+		if got := p.RenderWithLocale(LocaleUS); got != "="+want {
+			t.Errorf("Parse(%s) renders %q, want %q", formula, got, "="+want)
+		}
+	}
+	p.CommentPrefix = ""
+	p.Parse(`=A1 // note`)
+	if got := p.RenderWithLocale(LocaleUS); got != `=A1//note` {
+		t.Errorf("Parse without CommentPrefix renders %q", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=IF(SUM(A1:A3)>0,AVERAGE(B:B),0)`)
+	want := FormulaMetrics{References: 2, Functions: 3, Operators: 1, Literals: 2, Depth: 2}
+	if got := p.Metrics(); got != want {
+		t.Errorf("Metrics() = %+v, want %+v", got, want)
+	}
+	p.Parse(`=-(1+{2,"a"})%`)
+	want = FormulaMetrics{Operators: 3, Literals: 3, Depth: 3}
+	if got := p.Metrics(); got != want {
+		t.Errorf("Metrics() = %+v, want %+v", got, want)
+	}
+}
+
+func TestScientificNotationArguments(t *testing.T) {
+	p := ExcelParser()
+	f := `=SUM(1E-5,2E+3)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("1E-5", TokenTypeOperand, TokenSubTypeNumber),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("2E+3", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	f = `=MAX(12E-5,0.5E+3,1e-2)-E1`
+	var numbers []string
+	for _, tk := range p.Parse(f) {
+		if tk.TSubType == TokenSubTypeNumber {
+			numbers = append(numbers, tk.TValue)
+		}
+	}
+	if got, want := strings.Join(numbers, " "), "12E-5 0.5E+3 1e-2"; got != want {
+		t.Errorf("%s: numbers = %q, want %q", f, got, want)
+	}
+}
+
+func TestAssociativity(t *testing.T) {
+	for _, operator := range []string{"^", "-", "/", "+", "*", "&", "="} {
+		if !Associativity(operator) {
+			t.Errorf("Associativity(%q) = false, want left-associative", operator)
+		}
+	}
+	n := mustParseAST(t, `=2^3^2`)
+	if n.Children[0].Token.TValue != "^" || n.Children[1].Token.TValue != "2" {
+		t.Errorf("ParseAST(=2^3^2) = %s, want (2^3)^2", RenderAST(n))
+	}
+	if got := RenderAST(mustParseAST(t, `=2^(3^2)`)); got != `=2^(3^2)` {
+		t.Errorf("RenderAST(=2^(3^2)) = %s", got)
+	}
+}
+
+func TestComparatorSubtypes(t *testing.T) {
+	for _, op := range []string{"=", "<", ">", "<=", ">=", "<>"} {
+		for _, f := range []string{"=A1" + op + "B1", "=IF(SUM(A1) " + op + " 2,1,0)", "=(1)" + op + "-1"} {
+			p := ExcelParser()
+			found := false
+			for _, tk := range p.Parse(f) {
+				if tk.TValue == op {
+					found = true
+					if tk.TType != TokenTypeOperatorInfix || tk.TSubType != TokenSubTypeLogical {
+						t.Errorf("%s: %q = %v, want logical infix operator", f, op, tk)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("%s: no %q operator token", f, op)
+			}
+		}
+	}
+}
+
+func TestRequalify(t *testing.T) {
+	for _, c := range []struct {
+		formula, sheet, want string
+	}{
+		{`=A1+Sheet3!B1`, "Sheet1", `=Sheet1!A1+Sheet3!B1`},
+		{`=SUM($A$1:B2,C:C)`, "My Sheet", `=SUM('My Sheet'!$A$1:B2,'My Sheet'!C:C)`},
+		{`=A1&"B1"`, "It's", `='It''s'!A1&"B1"`},
+		{`=A1`, "R1C1", `='R1C1'!A1`},
+	} {
+		p := ExcelParser()
+		p.Parse(c.formula)
+		if got, err := p.Requalify(c.sheet); err != nil || got != c.want {
+			t.Errorf("Requalify(%s) on %s = %q, %v, want %q", c.sheet, c.formula, got, err, c.want)
+		}
+	}
+	p := ExcelParser()
+	p.Parse(`=A1`)
+	if _, err := p.Requalify(""); err == nil {
+		t.Error("Requalify with empty sheet: want error")
+	}
+}
+
+func TestTokenString(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=SUM(A1)+1`)
+	if got, want := p.Tokens.Items[0].String(), "SUM<Function/Start>"; got != want {
+		t.Errorf("Token.String() = %q, want %q", got, want)
+	}
+	want := "SUM<Function/Start> A1<Operand/Range> <Function/Stop> +<OperatorInfix/Math> 1<Operand/Number>"
+	if got := p.Tokens.String(); got != want {
+		t.Errorf("Tokens.String() = %q, want %q", got, want)
+	}
+	if got := fmt.Sprintf("%v", p.Tokens.Items[1]); got != "A1<Operand/Range>" {
+		t.Errorf("%%v of token = %q", got)
+	}
+}
+
+func TestTextEntryAware(t *testing.T) {
+	p := ExcelParser()
+	p.TextEntryAware = true
+	assertTokens(t, `'=1+1`, p.Parse(`'=1+1`), []Token{fToken("=1+1", TokenTypeOperand, TokenSubTypeText)})
+	if tk, ok := p.TokenAt(2); !ok || tk.TValue != "=1+1" {
+		t.Errorf("TokenAt(2) = %v, %v", tk, ok)
+	}
+	f := `='My Sheet'!A1`
+	assertTokens(t, f, p.Parse(f), []Token{fToken("'My Sheet'!A1", TokenTypeOperand, TokenSubTypeRange)})
+
+	p.TextEntryAware = false
+	if tokens := p.Parse(`'=1+1`); len(tokens) == 1 && tokens[0].TSubType == TokenSubTypeText {
+		t.Errorf("Parse('=1+1) without TextEntryAware = %v", tokens)
+	}
+}
+
+func TestLintWholeReferences(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=SUM(A:A)`:              {"A:A refers to whole columns"},
+		`=SUM(A1:A100)`:          nil,
+		`=SUM(Sheet1!$1:$2,B:C)`: {"Sheet1!$1:$2 refers to whole rows", "B:C refers to whole columns"},
+		`=SUM(XFE1,A:A)`:         {"column of XFE1 is beyond XFD", "A:A refers to whole columns"},
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, issue := range p.Lint() {
+			got = append(got, issue.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Lint(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestQualifiedSpill(t *testing.T) {
+	p := ExcelParser()
+	for _, f := range []string{`=SUM(Sheet1!A1#)`, `=SUM('My Sheet'!$B$2#)`} {
+		assertTokens(t, f, p.Parse(f), []Token{
+			fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+			fToken(f[5:len(f)-1], TokenTypeOperand, TokenSubTypeRange),
+			fToken("", TokenTypeFunction, TokenSubTypeStop),
+		})
+	}
+	p.SpillAsOperator = true
+	f := `=SUM(Sheet1!A1#)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("Sheet1!A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("#", TokenTypeOperatorPostfix, TokenSubTypeSpill),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	for _, spillAsOperator := range []bool{false, true} {
+		p.SpillAsOperator = spillAsOperator
+		f = `=Sheet1!A1#REF!`
+		assertTokens(t, f, p.Parse(f), []Token{
+			fToken("Sheet1!A1", TokenTypeUnknown, ""),
+			fToken("#REF!", TokenTypeOperand, TokenSubTypeError),
+		})
+		f = `='My Sheet'!$B$2#DIV/0!`
+		assertTokens(t, f, p.Parse(f), []Token{
+			fToken("'My Sheet'!$B$2", TokenTypeUnknown, ""),
+			fToken("#DIV/0!", TokenTypeOperand, TokenSubTypeError),
+		})
+	}
+	f = `=Sheet1!#REF!`
+	if tokens := p.Parse(f); tokens[len(tokens)-1].TSubType != TokenSubTypeError {
+		t.Errorf("Parse(%s) = %v, want a trailing error operand", f, tokens)
+	}
+}
+
+func TestRenderAST(t *testing.T) {
+	for _, f := range []string{
+		`=1+2*3`,
+		`=(1+2)*3`,
+		`=1-(2-3)`,
+		`=2^3^2`,
+		`=2^(3^2)`,
+		`=-2^2`,
+		`=-(2^2)`,
+		`=-5%`,
+		`=(A1+B1)%`,
+		`=IF(SUM(A1:A3)>0,AVERAGE(B:B)*2,MAX(1,2)-MIN(C1,-D1))`,
+		`=A1&(B1=C1)&"x""y"`,
+		`=SUM((A1,B1),C1)`,
+		`=SUM(A1:A3 B2:B4)/COUNT()`,
+		`={1,2;3,4}*{5;6}`,
+		`=--A1`,
+	} {
+		n, err := ParseAST(f)
+		if err != nil {
+			t.Errorf("ParseAST(%s) error: %v", f, err)
+			continue
+		}
+		if got := RenderAST(n); !Equivalent(got, f) {
+			t.Errorf("RenderAST(ParseAST(%s)) = %q, not equivalent", f, got)
+		}
+	}
+	if got := RenderAST(mustParseAST(t, `=((1+2))*(3)`)); got != `=(1+2)*3` {
+		t.Errorf("RenderAST(ParseAST(=((1+2))*(3))) = %q, want %q", got, `=(1+2)*3`)
+	}
+	for _, f := range []string{``, `=SUM(A1`, `=1+`} {
+		if _, err := ParseAST(f); err == nil {
+			t.Errorf("ParseAST(%s): want error", f)
+		}
+	}
+}
+
+func mustParseAST(t *testing.T, formula string) *Node {
+	t.Helper()
+	n, err := ParseAST(formula)
+	if err != nil {
+		t.Fatalf("ParseAST(%s) error: %v", formula, err)
+	}
+	return n
+}
+
+func TestSplitRanges(t *testing.T) {
+	p := ExcelParser()
+	p.SplitRanges = true
+	f := `=A1:B2`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+		fToken("B2", TokenTypeOperand, TokenSubTypeRange),
+	})
+	f = `=Sheet1:Sheet3!A1`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("Sheet1", TokenTypeOperand, TokenSubTypeRange),
+		fToken(":", TokenTypeOperatorInfix, TokenSubType3DRange),
+		fToken("Sheet3!A1", TokenTypeOperand, TokenSubTypeRange),
+	})
+	f = `=SUM('a:b'!A1:A3)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("'a:b'!A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+		fToken("A3", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if tk, ok := p.TokenAt(13); !ok || tk.TValue != ":" {
+		t.Errorf("TokenAt(13) = %v, %v, want the range operator", tk, ok)
+	}
+	if got := p.Render(); got != `SUM('a:b'!A1:A3)` {
+		t.Errorf("Render() = %q", got)
+	}
+
+	p.SplitRanges = false
+	f = `=Sheet1:Sheet3!A1`
+	assertTokens(t, f, p.Parse(f), []Token{fToken("Sheet1:Sheet3!A1", TokenTypeOperand, TokenSubTypeRange)})
+}
+
+func TestTokensOfType(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=IF(SUM(A1,2)>1.5,MAX(3),"4")`)
+	var starts []string
+	for _, tk := range p.TokensOfType(TokenTypeFunction) {
+		if tk.TSubType == TokenSubTypeStart {
+			starts = append(starts, tk.TValue)
+		}
+	}
+	if got := strings.Join(starts, " "); got != "IF SUM MAX" {
+		t.Errorf("TokensOfType(Function) starts = %q, want %q", got, "IF SUM MAX")
+	}
+	var numbers []string
+	for _, tk := range p.TokensOfSubtype(TokenSubTypeNumber) {
+		numbers = append(numbers, tk.TValue)
+	}
+	if got := strings.Join(numbers, " "); got != "2 1.5 3" {
+		t.Errorf("TokensOfSubtype(Number) = %q, want %q", got, "2 1.5 3")
+	}
+	numbers2 := p.TokensOfSubtype(TokenSubTypeNumber)
+	numbers2[0].TValue = "changed"
+	if p.Tokens.Items[4].TValue != "2" {
+		t.Error("TokensOfSubtype() result shares the parsed tokens")
+	}
+	p.EmitEOF = true
+	p.Parse(`=SUM(A1,2)`)
+	if got := p.TokensOfType(TokenTypeEOF); len(got) != 0 {
+		t.Errorf("TokensOfType(EOF) with EmitEOF = %v, want none", got)
+	}
+	if got := p.TokensOfSubtype(""); len(got) != 1 || got[0].TType != TokenTypeArgument {
+		t.Errorf("TokensOfSubtype(\"\") with EmitEOF = %v, want the argument separator", got)
+	}
+}
+
+func TestParseCheckedComparisons(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=A1<>B1`:  "",
+		`=A1>=-B1`: "",
+		`=A1><B1`:  `invalid operator "><" at offset 3`,
+		`=A1=<B1`:  `invalid operator "=<" at offset 3`,
+		`=A1=>B1`:  `invalid operator "=>" at offset 3`,
+	} {
+		p := ExcelParser()
+		_, err := p.ParseChecked(formula)
+		if (err == nil && want != "") || (err != nil && err.Error() != want) {
+			t.Errorf("ParseChecked(%s) error = %v, want %q", formula, err, want)
+		}
+	}
+}
+
+func TestToDOT(t *testing.T) {
+	want := `digraph AST {
+	n0 [label="+ (OperatorInfix)"];
+	n1 [label="1 (Operand)"];
+	n0 -> n1;
+	n2 [label="* (OperatorInfix)"];
+	n3 [label="2 (Operand)"];
+	n2 -> n3;
+	n4 [label="3 (Operand)"];
+	n2 -> n4;
+	n0 -> n2;
+}
+`
+	if got := mustParseAST(t, `=1+2*3`).ToDOT(); got != want {
+		t.Errorf("ToDOT() = %s, want %s", got, want)
+	}
+	if got := mustParseAST(t, `="say ""hi"""`).ToDOT(); !strings.Contains(got, `n0 [label="say \"hi\" (Operand)"];`) {
+		t.Errorf("ToDOT() = %s, want escaped quotes", got)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=A1&"<b>"`)
+	want := `=<span class="Operand Range">A1</span><span class="OperatorInfix Concatenation">&amp;</span><span class="Operand Text">&#34;&lt;b&gt;&#34;</span>`
+	if got := p.RenderHTML(); got != want {
+		t.Errorf("RenderHTML() = %s, want %s", got, want)
+	}
+	p.Parse(`=SUM(A1,1)`)
+	want = `=<span class="Function Start">SUM(</span><span class="Operand Range">A1</span><span class="Argument">,</span><span class="Operand Number">1</span><span class="Function Stop">)</span>`
+	if got := p.RenderHTML(); got != want {
+		t.Errorf("RenderHTML() = %s, want %s", got, want)
+	}
+}
+
+func TestPrecedenceLevelsUsed(t *testing.T) {
+	for formula, want := range map[string]int{
+		`=A1+B1*C1^2`:       3,
+		`=A1+B1-C1`:         1,
+		`=SUM(A1)`:          0,
+		`=-A1%&"x"=(B1 C1)`: 5,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.PrecedenceLevelsUsed(); got != want {
+			t.Errorf("PrecedenceLevelsUsed(%s) = %d, want %d", formula, got, want)
+		}
+	}
+}
+
+func TestImplicitIntersectionTableReferences(t *testing.T) {
+	for _, formula := range []string{
+		`=[@[Sales Amount]]`,
+		`=@Table1[Amount]`,
+		`=SUM(Table1[@[Q1]:[Q2]])*2`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := "=" + p.Render(); got != formula {
+			t.Errorf("Render(%s) = %s", formula, got)
+		}
+	}
+	p := ExcelParser()
+	p.Parse(`=@Table1[Amount]`)
+	assertTokens(t, `=@Table1[Amount]`, p.Tokens.Items, []Token{
+		{TValue: "@Table1[Amount]", TType: TokenTypeOperand, TSubType: TokenSubTypeRange},
+	})
+}
+
+func TestValidateFunctionCalls(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=SUM A1:A3`:     {"SUM may be missing the parentheses of a function call"},
+		`=Total Sales`:   nil,
+		`=SUM(A1:A3)`:    nil,
+		`=average B1:B2`: {"average may be missing the parentheses of a function call"},
+	} {
+		p := ExcelParser()
+		p.KnownFunctions = map[string]struct{}{"SUM": {}, "AVERAGE": {}}
+		p.Parse(formula)
+		var got []string
+		for _, issue := range p.ValidateFunctionCalls() {
+			got = append(got, issue.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("ValidateFunctionCalls(%s) = %q, want %q", formula, got, want)
+		}
+	}
+	p := ExcelParser()
+	p.Parse(`=SUM A1:A3`)
+	if issues := p.ValidateFunctionCalls(); len(issues) != 0 {
+		t.Errorf("ValidateFunctionCalls(=SUM A1:A3) without KnownFunctions = %v, want none", issues)
+	}
+}
+
+func TestR1C1References(t *testing.T) {
+	f := `=R1+C1`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("R1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("C1", TokenTypeOperand, TokenSubTypeRange),
+	})
+	p.RefineRanges = true
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("R1", TokenTypeOperand, TokenSubTypeCell),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("C1", TokenTypeOperand, TokenSubTypeCell),
+	})
+	p.R1C1 = true
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("R1", TokenTypeOperand, TokenSubTypeRow),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("C1", TokenTypeOperand, TokenSubTypeColumn),
+	})
+	f = `=SUM(R[-1]C:R1C2,R1C1)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("R[-1]C:R1C2", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("R1C1", TokenTypeOperand, TokenSubTypeCell),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+}
+
+func TestLintDuplicateOperators(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=A1**B1`: {"operator * at offset 4 follows operator *"},
+		`=A1+-B1`: nil,
+		`=A1++B1`: nil,
+		`=A1*/B1`: {"operator / at offset 4 follows operator *"},
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, issue := range p.Lint() {
+			got = append(got, issue.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Lint(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestRenderWithRefMapper(t *testing.T) {
+	ref := func(string) string { return "REF" }
+	for formula, want := range map[string]string{
+		`=A1+B1*2`:                `=REF+REF*2`,
+		`=SUM(Sheet1!A1:B2,"A1")`: `=SUM(REF,"A1")`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.RenderWithRefMapper(ref); got != want {
+			t.Errorf("RenderWithRefMapper(%s) = %s, want %s", formula, got, want)
+		}
+	}
+	p := ExcelParser()
+	p.DetectNames = true
+	p.Parse(`=TaxRate*A1`)
+	if got := p.RenderWithRefMapper(strings.ToLower); got != `=taxrate*a1` {
+		t.Errorf("RenderWithRefMapper(=TaxRate*A1) = %s", got)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=A1&B1+C1>2`:      "&/Concatenation +/Math >/Logical",
+		`=-A1%+(B1 C1)`:    "-/ %/ +/Math /Intersection",
+		`=SUM((A1,B1))`:    ",/Union",
+		`=SUM(1,2)`:        "",
+		`=Sheet1!A1:B2*-1`: "*/Math -/",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, tk := range p.Operators() {
+			got = append(got, tk.TValue+"/"+tk.TSubType)
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("Operators(%s) = %q, want %q", formula, strings.Join(got, " "), want)
+		}
+	}
+}
+
+func TestKeepNoops(t *testing.T) {
+	f := `=1++2`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("1", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("2", TokenTypeOperand, TokenSubTypeNumber),
+	})
+	p.KeepNoops = true
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("1", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("+", TokenTypeNoop, ""),
+		fToken("2", TokenTypeOperand, TokenSubTypeNumber),
+	})
+	if got := p.Render(); got != "1++2" {
+		t.Errorf("Render(%s) = %s, want 1++2", f, got)
+	}
+	rpn, err := p.ToRPN()
+	if err != nil || len(rpn) != 3 {
+		t.Errorf("ToRPN(%s) = %v, %v", f, rpn, err)
+	}
+}
+
+func TestUsesExternalData(t *testing.T) {
+	for formula, want := range map[string]bool{
+		`=WEBSERVICE("http://x")`:                     true,
+		`=SUM(A1)`:                                    false,
+		`=IF(A1,_xlfn.webservice(B1),"")`:             true,
+		`=HYPERLINK("https://example.com","Example")`: true,
+		`=RTD2(A1)`:                                   false,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.UsesExternalData(); got != want {
+			t.Errorf("UsesExternalData(%s) = %t, want %t", formula, got, want)
+		}
+	}
+}
+
+func TestMultiLine(t *testing.T) {
+	f := "=SUM(A1,\n  B1)"
+	p := ExcelParser()
+	p.MultiLine = true
+	tokens := p.Parse(f)
+	assertTokens(t, f, tokens, []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("B1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if len(tokens) == 5 {
+		if tk := tokens[0]; tk.Line != 1 || tk.Column != 2 {
+			t.Errorf("SUM at line %d column %d, want line 1 column 2", tk.Line, tk.Column)
+		}
+		if tk := tokens[3]; tk.Line != 2 || tk.Column != 3 {
+			t.Errorf("B1 at line %d column %d, want line 2 column 3", tk.Line, tk.Column)
+		}
+	}
+	p.MultiLine = false
+	if tokens := p.Parse(f); tokens[0].Line != 0 {
+		t.Errorf("Line = %d without MultiLine, want 0", tokens[0].Line)
+	}
+}
+
+func TestDynamicReferenceArgs(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=INDIRECT("Sheet1!A1")`:                   {`INDIRECT("Sheet1!A1")`},
+		`=SUM(OFFSET(A1,1,0),indirect("A"&ROW()))`: {`OFFSET(A1|1|0)`, `indirect("A"&ROW())`},
+		`=INDIRECT(A1)+OFFSET(B1,1,1)`:             {`INDIRECT(A1)`, `OFFSET(B1|1|1)`},
+		`=OFFSET(INDIRECT(A1),1,1)`:                {`OFFSET(INDIRECT(A1)|1|1)`, `INDIRECT(A1)`},
+		`=SUM(A1:B2)`:                              nil,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, call := range p.DynamicReferenceArgs() {
+			var args []string
+			for _, arg := range call.Args {
+				args = append(args, strings.Join(renderPieces(arg, LocaleUS), ""))
+			}
+			got = append(got, call.Function.TValue+"("+strings.Join(args, "|")+")")
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("DynamicReferenceArgs(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestFunctionNameBeforeSpace(t *testing.T) {
+	f := `=SUM (A1:A3)`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A1:A3", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if got := p.Render(); got != "SUM(A1:A3)" {
+		t.Errorf("Render(%s) = %s, want SUM(A1:A3)", f, got)
+	}
+	f = `=A1 (B1)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeOperatorInfix, TokenSubTypeIntersection),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStart),
+		fToken("B1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStop),
+	})
+	f = `=TaxRate (A1:B2)`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("TaxRate", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeOperatorInfix, TokenSubTypeIntersection),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStart),
+		fToken("A1:B2", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStop),
+	})
+	if got := p.Parse(`=_xlfn.xlookup  (A1,B:B,C:C)`); len(got) != 7 || got[0].TValue != "xlookup" {
+		t.Errorf("Parse(=_xlfn.xlookup  (A1,B:B,C:C)) = %v, want function call", got)
+	}
+	if got := p.Parse(`=MYFUNC (A1:A3)`); len(got) != 5 || got[1].TSubType != TokenSubTypeIntersection {
+		t.Errorf("Parse(=MYFUNC (A1:A3)) without KnownFunctions = %v, want intersection", got)
+	}
+	p.KnownFunctions = map[string]struct{}{"MYFUNC": {}}
+	if got := p.Parse(`=myfunc (A1:A3)`); len(got) != 3 || got[0].TType != TokenTypeFunction {
+		t.Errorf("Parse(=myfunc (A1:A3)) = %v, want function call", got)
+	}
+}
+
+func TestActiveArgument(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=IF(A1>0,SUM(B1,B2),0)`)
+	for _, c := range []struct {
+		offset   int
+		function string
+		argIndex int
+		ok       bool
+	}{
+		{0, "", 0, false},
+		{4, "IF", 0, true},
+		{9, "IF", 1, true},
+		{13, "SUM", 0, true},
+		{16, "SUM", 1, true},
+		{18, "SUM", 1, true},
+		{19, "IF", 1, true},
+		{20, "IF", 2, true},
+		{22, "", 0, false},
+	} {
+		function, argIndex, ok := p.ActiveArgument(c.offset)
+		if function != c.function || argIndex != c.argIndex || ok != c.ok {
+			t.Errorf("ActiveArgument(%d) = %q, %d, %t, want %q, %d, %t", c.offset, function, argIndex, ok, c.function, c.argIndex, c.ok)
+		}
+	}
+	p.Parse(`=SUM({1,2},(A1,B1),C1)`)
+	if function, argIndex, _ := p.ActiveArgument(20); function != "SUM" || argIndex != 2 {
+		t.Errorf("ActiveArgument(20) = %q, %d, want \"SUM\", 2", function, argIndex)
+	}
+}
+
+func TestKnownFunctions(t *testing.T) {
+	f := `=SUM+TaxRate`
+	p := ExcelParser()
+	p.DetectNames = true
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeOperand, TokenSubTypeName),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("TaxRate", TokenTypeOperand, TokenSubTypeName),
+	})
+	if issues := p.Lint(); len(issues) != 0 {
+		t.Errorf("Lint(%s) = %v, want none", f, issues)
+	}
+	p.KnownFunctions = map[string]struct{}{"SUM": {}}
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeOperand, TokenSubTypeRange),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("TaxRate", TokenTypeOperand, TokenSubTypeName),
+	})
+	issues := p.Lint()
+	if len(issues) != 1 || issues[0].Message != "function name SUM is used as an operand" {
+		t.Errorf("Lint(%s) = %v", f, issues)
+	}
+	p.Parse(`=sum(A1)`)
+	if issues := p.Lint(); len(issues) != 0 {
+		t.Errorf("Lint(=sum(A1)) = %v, want none", issues)
+	}
+}
+
+func TestFoldStringConcatenation(t *testing.T) {
+	for formula, want := range map[string]string{
+		`="a"&"b"&"c"`:         `="abc"`,
+		`=A1&"b"`:              `=A1&"b"`,
+		`=A1&"a"&"b"`:          `=A1&"ab"`,
+		`=A1+"a"&"b"`:          `=A1+"a"&"b"`,
+		`="a"&"b"^2`:           `="a"&"b"^2`,
+		`=IF(A1="x"&"y",1,0)`:  `=IF(A1="xy",1,0)`,
+		`=CONCAT("a"""&"b",1)`: `=CONCAT("a""b",1)`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		p.FoldStringConcatenation()
+		if got := p.RenderWithLocale(LocaleUS); got != want {
+			t.Errorf("FoldStringConcatenation(%s) = %s, want %s", formula, got, want)
+		}
+	}
+	p := ExcelParser()
+	parsed := p.Parse(`="a"&"b"`)
+	p.FoldStringConcatenation()
+	if len(parsed) != 3 || parsed[0].TValue != "a" || parsed[2].TValue != "b" {
+		t.Errorf("FoldStringConcatenation(=\"a\"&\"b\") changed the tokens returned by Parse: %v", parsed)
+	}
+}
+
+func TestLintReferenceGrammar(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=Sheet 1!A1`:              {"1!A1 is not a valid reference"},
+		`='Sheet 1'!A1`:            nil,
+		`='It''s'!A1+[1]Sheet1!B2`: nil,
+		`=SUM(Sheet1:Sheet3!A1)`:   nil,
+		`=Table1[Amount]+[@Qty]`:   nil,
+		`=A1$`:                     {"A1$ is not a valid reference"},
+		`=My-Sheet!A1`:             nil,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, issue := range p.Lint() {
+			got = append(got, issue.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Lint(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestRenderedLen(t *testing.T) {
+	for _, formula := range []string{
+		`=SUM(A1:B2,"text")`,
+		`=IF((A1 B1)>0,"é",{1,2;3,4})`,
+		`=-A1%+_xlfn.CONCAT("a""b",Sheet1!C1)`,
+		`=`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got, want := p.RenderedLen(), len(p.Render()); got != want {
+			t.Errorf("RenderedLen(%s) = %d, want %d", formula, got, want)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	for value, want := range map[string][3]string{
+		`Sheet1!LocalName`:     {"Sheet1", "LocalName", "true"},
+		`'My Sheet'!LocalName`: {"My Sheet", "LocalName", "true"},
+		`'It''s'!Rate`:         {"It's", "Rate", "true"},
+		`TaxRate`:              {"", "TaxRate", "true"},
+		`Sheet1!A1`:            {"", "", "false"},
+		`A1`:                   {"", "", "false"},
+		`!Rate`:                {"", "", "false"},
+	} {
+		sheet, name, ok := ParseName(value)
+		if got := [3]string{sheet, name, fmt.Sprint(ok)}; got != want {
+			t.Errorf("ParseName(%s) = %q, want %q", value, got, want)
+		}
+	}
+	f := `=Sheet1!LocalName+GlobalName`
+	p := ExcelParser()
+	p.DetectNames = true
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("Sheet1!LocalName", TokenTypeOperand, TokenSubTypeName),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("GlobalName", TokenTypeOperand, TokenSubTypeName),
+	})
+}
+
+func TestRenderWithErrorPlaceholder(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=A1+#REF!`:                   `=A1+<ERR>`,
+		`=IFERROR(A1/B1,#N/A)&"#N/A"`: `=IFERROR(A1/B1,<ERR>)&"#N/A"`,
+		`=SUM(A1)`:                    `=SUM(A1)`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.RenderWithErrorPlaceholder("<ERR>"); got != want {
+			t.Errorf("RenderWithErrorPlaceholder(%s) = %s, want %s", formula, got, want)
+		}
+	}
+}
+
+func TestBooleanTree(t *testing.T) {
+	var describe func(n *Node) string
+	describe = func(n *Node) string {
+		if len(n.Children) == 0 {
+			return n.Token.TValue
+		}
+		var children []string
+		for _, child := range n.Children {
+			children = append(children, describe(child))
+		}
+		return n.Token.TValue + "(" + strings.Join(children, ",") + ")"
+	}
+	for formula, want := range map[string]string{
+		`=AND(A1>0,OR(B1,NOT(C1)))`:            "AND(>(A1,0),OR(B1,NOT(C1)))",
+		`=AND(A1,and(B1,OR(C1,OR(D1,E1))))`:    "AND(A1,B1,OR(C1,D1,E1))",
+		`=NOT(NOT(A1))`:                        "NOT(NOT(A1))",
+		`=OR(XOR(A1,XOR(B1,C1)),SUM(AND(D1)))`: "OR(XOR(A1,B1,C1),SUM(AND(D1)))",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		n, err := p.BooleanTree()
+		if err != nil {
+			t.Errorf("BooleanTree(%s) error: %v", formula, err)
+			continue
+		}
+		if got := describe(n); got != want {
+			t.Errorf("BooleanTree(%s) = %s, want %s", formula, got, want)
+		}
+	}
+}
+
+func TestRedundantParens(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=(A1)+B1`:        "[0]",
+		`=(A1+B1)*C1`:     "[]",
+		`=(A1*B1)+C1`:     "[0]",
+		`=A1-(B1-C1)`:     "[]",
+		`=(A1-B1)-C1`:     "[0]",
+		`=SUM((A1+B1),2)`: "[1]",
+		`=SUM((A1,B1))`:   "[]",
+		`=((A1+B1))*C1`:   "[1]",
+		`=-(A1^2)`:        "[]",
+		`=(-A1)^2`:        "[0]",
+		`=(A1+B1)%`:       "[]",
+		`=(A1 B1)+C1`:     "[0]",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := fmt.Sprint(p.RedundantParens()); got != want {
+			t.Errorf("RedundantParens(%s) = %s, want %s", formula, got, want)
+		}
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=SUM(A1:B2,"x")*2`: "",
+		`=SUM(A1`:           `unclosed '(' at offset 4`,
+		`=A1&"abc`:          `unterminated '"' at offset 4`,
+		`=A1+`:              `missing operand after "+" at offset 3`,
+		`=%5`:               `unexpected "%" at offset 1`,
+		`=A1><B1`:           `invalid operator "><" at offset 3`,
+		`=`:                 "formula is not a single expression at offset 1",
+		`=IF(A1,,)`:         "",
+		`=SUM(,A1)`:         "",
+		`=IF(A1,,1)+{1,,2}`: "",
+	} {
+		p := ExcelParser()
+		got := ""
+		if err := p.FirstError(formula); err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("FirstError(%s) = %q, want %q", formula, got, want)
+		}
+	}
+	p := ExcelParser()
+	if err := p.FirstError(`=(A1`); err == nil || err.Offset != 1 || err.Message != `unclosed '('` {
+		t.Errorf("FirstError(=(A1) = %v", err)
+	}
+	for _, formula := range []string{`=IF(A1,,)`, `=SUM(,A1)`} {
+		if got := RenderAST(mustParseAST(t, formula)); got != formula {
+			t.Errorf("RenderAST(%s) = %s", formula, got)
+		}
+	}
+}
+
+func TestParserForDialect(t *testing.T) {
+	f := `=ARRAYFORMULA(SUM(Sheet1!A2:A,B2:2))`
+	want := []Token{
+		fToken("ARRAYFORMULA", TokenTypeFunction, TokenSubTypeStart),
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("Sheet1!A2:A", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("B2:2", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	}
+	p := ParserForDialect(DialectGoogleSheets)
+	assertTokens(t, f, p.Parse(f), want)
+	if issues := p.Lint(); len(issues) != 0 {
+		t.Errorf("Lint(%s) = %v, want none for Google Sheets", f, issues)
+	}
+	p = ParserForDialect(DialectExcel)
+	assertTokens(t, f, p.Parse(f), want)
+	if issues := p.Lint(); len(issues) != 2 {
+		t.Errorf("Lint(%s) = %v, want 2 issues for Excel", f, issues)
+	}
+}
+
+func TestThreeDReferences(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=SUM(Sheet1:Sheet3!A1)`:                     "Sheet1:Sheet3!A1",
+		`=SUM(A1:B2,Sheet1!C1)`:                      "",
+		`=SUM('Jan 1:Mar 1'!A1:B2)+Sheet1:Sheet2!C1`: "'Jan 1:Mar 1'!A1:B2|Sheet1:Sheet2!C1",
+	} {
+		for _, split := range []bool{false, true} {
+			p := ExcelParser()
+			p.SplitRanges = split
+			p.Parse(formula)
+			if got := strings.Join(p.ThreeDReferences(), "|"); got != want {
+				t.Errorf("ThreeDReferences(%s) with SplitRanges %t = %q, want %q", formula, split, got, want)
+			}
+		}
+	}
+}
+
+func TestEmitEOF(t *testing.T) {
+	f := `=SUM(A1,2)`
+	p := ExcelParser()
+	if tokens := p.Parse(f); tokens[len(tokens)-1].TType == TokenTypeEOF {
+		t.Errorf("Parse(%s) emitted EOF by default", f)
+	}
+	p.EmitEOF = true
+	tokens := p.Parse(f)
+	count := 0
+	for _, tk := range tokens {
+		if tk.TType == TokenTypeEOF {
+			count++
+		}
+	}
+	if last := tokens[len(tokens)-1]; count != 1 || last.TType != TokenTypeEOF || last.Start != len(f) {
+		t.Errorf("Parse(%s) = %v, want a single EOF token at offset %d", f, tokens, len(f))
+	}
+	if got := p.Render(); got != "SUM(A1,2)" {
+		t.Errorf("Render(%s) = %s", f, got)
+	}
+	if n, err := p.ast(); err != nil || n.Token.TValue != "SUM" {
+		t.Errorf("ast(%s) = %v, %v", f, n, err)
+	}
+}
+
+func TestParseCheckedTrailingOperators(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=A1+`:       `missing operand after "+" at offset 3`,
+		`=A1%`:       "",
+		`=A1`:        "",
+		`=SUM(A1*)`:  `missing operand after "*" at offset 7`,
+		`=IF(-,1,2)`: `missing operand after "-" at offset 4`,
+		`=A1+-B1`:    "",
+	} {
+		p := ExcelParser()
+		_, err := p.ParseChecked(formula)
+		if (err == nil && want != "") || (err != nil && err.Error() != want) {
+			t.Errorf("ParseChecked(%s) error = %v, want %q", formula, err, want)
+		}
+	}
+}
+
+func TestConcatenationParts(t *testing.T) {
+	for formula, want := range map[string]string{
+		`="Hello "&A1&"!"`:           `"Hello "|A1|"!"`,
+		`=UPPER(A1&B1)&TEXT(C1,"0")`: `UPPER(A1&B1)|TEXT(C1,"0")`,
+		`=A1+1&"x"`:                  `A1+1|"x"`,
+		`=SUM(A1&B1)`:                "",
+		`=A1&B1="x"`:                 "",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		parts, ok := p.ConcatenationParts()
+		var got []string
+		for _, part := range parts {
+			got = append(got, strings.Join(renderPieces(part, LocaleUS), ""))
+		}
+		if strings.Join(got, "|") != want || ok != (want != "") {
+			t.Errorf("ConcatenationParts(%s) = %q, %t, want %q", formula, got, ok, want)
+		}
+	}
+	p := ExcelParser()
+	p.EmitEOF = true
+	p.Parse(`="a"&A1`)
+	if parts, ok := p.ConcatenationParts(); !ok || len(parts) != 2 || len(parts[1]) != 1 || parts[1][0].TValue != "A1" {
+		t.Errorf("ConcatenationParts(=\"a\"&A1) with EmitEOF = %v, %t", parts, ok)
+	}
+}
+
+func TestAnchorReferences(t *testing.T) {
+	for formula, want := range map[string][2]string{
+		`=A1+$B$2`:                     {`=$A$1+$B$2`, `=A1+B2`},
+		`=SUM(Sheet1!A$1:$B2,C:C,3:3)`: {`=SUM(Sheet1!$A$1:$B$2,$C:$C,$3:$3)`, `=SUM(Sheet1!A1:B2,C:C,3:3)`},
+		`=TaxRate*"A1"`:                {`=TaxRate*"A1"`, `=TaxRate*"A1"`},
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		for i, absolute := range []bool{true, false} {
+			got, err := p.AnchorReferences(absolute)
+			if err != nil || got != want[i] {
+				t.Errorf("AnchorReferences(%s, %t) = %s, %v, want %s", formula, absolute, got, err, want[i])
+			}
+		}
+	}
+	p := ExcelParser()
+	p.R1C1 = true
+	p.Parse(`=R1C1`)
+	if _, err := p.AnchorReferences(true); err == nil {
+		t.Error("AnchorReferences() in R1C1 mode, want error")
+	}
+}
+
+func TestArrayLocale(t *testing.T) {
+	f := `={1,5;2,5}`
+	p := ExcelParser()
+	p.Locale = LocaleDE
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("ARRAY", TokenTypeFunction, TokenSubTypeStart),
+		fToken("ARRAYROW", TokenTypeFunction, TokenSubTypeStart),
+		fToken("1.5", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("ARRAYROW", TokenTypeFunction, TokenSubTypeStart),
+		fToken("2.5", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if got := p.RenderWithLocale(LocaleDE); got != f {
+		t.Errorf("RenderWithLocale(%s) = %s", f, got)
+	}
+	f = `={1\"a";WAHR\0,25}`
+	p.Parse(f)
+	if got := p.RenderWithLocale(LocaleUS); got != `={1,"a";WAHR,0.25}` {
+		t.Errorf("RenderWithLocale(%s) = %s", f, got)
+	}
+	if err := p.ValidateArrays(); err != nil {
+		t.Errorf("ValidateArrays(%s) = %v", f, err)
+	}
+	p = ExcelParser()
+	if got := p.Parse(`={1,5;2,5}`); len(got) != 13 {
+		t.Errorf("Parse(={1,5;2,5}) in LocaleUS = %v, want 2 rows of 2 elements", got)
+	}
+}
+
+func TestRootFunction(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=SUM(A1:A3)`:               "SUM",
+		`=-VLOOKUP(A1,B:C,2,FALSE)`: "VLOOKUP",
+		`=1+SUM(A1)`:                "",
+		`=SUM(A1)+1`:                "",
+		`=SUM(A1)*MAX(B1)`:          "",
+		`={1,2}`:                    "",
+		`=A1`:                       "",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		got, ok := p.RootFunction()
+		if got != want || ok != (want != "") {
+			t.Errorf("RootFunction(%s) = %q, %t, want %q", formula, got, ok, want)
+		}
+	}
+	p := ExcelParser()
+	p.EmitEOF = true
+	p.Parse(`=-SUM(A1:A3)`)
+	if got, ok := p.RootFunction(); got != "SUM" || !ok {
+		t.Errorf("RootFunction(=-SUM(A1:A3)) with EmitEOF = %q, %t", got, ok)
+	}
+}
+
+func TestNormalizeReferences(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=a1+sheet1!b2`:            `=A1+sheet1!B2`,
+		`=SUM('my sheet'!$c$1:d2)`: `=SUM('my sheet'!$C$1:D2)`,
+		`=taxRate*a:b+"a1"`:        `=taxRate*A:B+"a1"`,
+	} {
+		p := ExcelParser()
+		p.NormalizeReferences = true
+		p.Parse(formula)
+		if got := p.RenderWithLocale(LocaleUS); got != want {
+			t.Errorf("NormalizeReferences(%s) = %s, want %s", formula, got, want)
+		}
+	}
+}
+
+func TestSpillsArray(t *testing.T) {
+	for formula, want := range map[string]bool{
+		`=SORT(A1:A10)`:                         true,
+		`=SUM(A1:A10)`:                          false,
+		`=SUM(_xlfn._xlws.filter(A1:A9,B1:B9))`: true,
+		`=A1#`:                                  false,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.SpillsArray(); got != want {
+			t.Errorf("SpillsArray(%s) = %t, want %t", formula, got, want)
+		}
+	}
+}
+
+func TestImplicitIntersectionFunctions(t *testing.T) {
+	f := `=IF(@SUM(A1:A3),@_xlfn.MAXIFS(Table1[@Col],B1:B3,">0"),@Table1[Amount])`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("IF", TokenTypeFunction, TokenSubTypeStart),
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A1:A3", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("MAXIFS", TokenTypeFunction, TokenSubTypeStart),
+		fToken("Table1[@Col]", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("B1:B3", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken(">0", TokenTypeOperand, TokenSubTypeText),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("@Table1[Amount]", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	f = `=Table1[@Col]`
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("Table1[@Col]", TokenTypeOperand, TokenSubTypeRange),
+	})
+}
+
+func TestExport(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=SUM(A1,"x")`)
+	want := []ExportToken{
+		{Value: "SUM", Type: TokenTypeFunction, SubType: TokenSubTypeStart},
+		{Value: "A1", Type: TokenTypeOperand, SubType: TokenSubTypeRange},
+		{Value: ",", Type: TokenTypeArgument},
+		{Value: "x", Type: TokenTypeOperand, SubType: TokenSubTypeText},
+		{Type: TokenTypeFunction, SubType: TokenSubTypeStop},
+	}
+	if got := p.Export(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Export() = %v, want %v", got, want)
+	}
+	p.EmitEOF = true
+	p.Parse(`=SUM(A1,"x")`)
+	if got := p.Export(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Export() with EmitEOF = %v, want %v", got, want)
+	}
+}
+
+func TestSplitAbsoluteRanges(t *testing.T) {
+	p := ExcelParser()
+	p.SplitRanges = true
+	for f, want := range map[string][]Token{
+		`=$A$1:$B$2`: {
+			fToken("$A$1", TokenTypeOperand, TokenSubTypeRange),
+			fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+			fToken("$B$2", TokenTypeOperand, TokenSubTypeRange),
+		},
+		`=Sheet1!$A1:B$2`: {
+			fToken("Sheet1!$A1", TokenTypeOperand, TokenSubTypeRange),
+			fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+			fToken("B$2", TokenTypeOperand, TokenSubTypeRange),
+		},
+	} {
+		tokens := p.Parse(f)
+		assertTokens(t, f, tokens, want)
+		if len(tokens) == 3 && (tokens[1].Start != len(tokens[0].TValue)+1 || tokens[2].End != len(f)) {
+			t.Errorf("Parse(%s) positions = %d, %d", f, tokens[1].Start, tokens[2].End)
+		}
+	}
+}
+
+func TestLintDivisionByZero(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=A1/0`:             {"division by zero at offset 3"},
+		`=A1/B1`:            nil,
+		`=A1/(1-1)`:         {"division by zero at offset 3"},
+		`=SUM(A1/-0%,B1/2)`: {"division by zero at offset 7"},
+		`=A1/(1/0)`:         {"division by zero at offset 6"},
+		`=A1/"0"`:           nil,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, issue := range p.Lint() {
+			got = append(got, issue.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Lint(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestRenderSpaced(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=A1+B1*2`:                  `=A1 + B1 * 2`,
+		`=-A1%&"x"`:                 `=-A1% & "x"`,
+		`=IF(A1>=-1,SUM(A1:B2 C1))`: `=IF(A1 >= -1,SUM(A1:B2 C1))`,
+		`=SUM((A1,B1))`:             `=SUM((A1,B1))`,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		got := p.RenderSpaced()
+		if got != want {
+			t.Errorf("RenderSpaced(%s) = %s, want %s", formula, got, want)
+		}
+		if !Equivalent(formula, got) {
+			t.Errorf("RenderSpaced(%s) = %s is not equivalent", formula, got)
+		}
+	}
+}
+
+func TestIsNegated(t *testing.T) {
+	for formula, want := range map[string]bool{
+		`=NOT(A1>0)`:    true,
+		`=A1>0`:         false,
+		`=-(A1>0)`:      true,
+		`=1-(A1>0)`:     true,
+		`=2-(A1>0)`:     false,
+		`=-A1+B1`:       false,
+		`=not(AND(A1))`: true,
+		`=NOT(A1)+1`:    false,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.IsNegated(); got != want {
+			t.Errorf("IsNegated(%s) = %t, want %t", formula, got, want)
+		}
+	}
+}
+
+func TestSetOperandClassifier(t *testing.T) {
+	f := `=TODAY_DATE+1+A1`
+	p := ExcelParser()
+	p.SetOperandClassifier(func(value string) string {
+		if strings.HasPrefix(value, "TODAY") {
+			return "Date"
+		}
+		return ""
+	})
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("TODAY_DATE", TokenTypeOperand, "Date"),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("1", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+	})
+	p.SetOperandClassifier(nil)
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("TODAY_DATE", TokenTypeOperand, TokenSubTypeRange),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("1", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+	})
+}
+
+func TestLintMixedReferenceStyles(t *testing.T) {
+	for formula, want := range map[string][]string{
+		`=A1+B2*Sheet1!C3`:    nil,
+		`=R1C1+R[-1]C`:        nil,
+		`=A1+R[-1]C2`:         {"R[-1]C2 is in R1C1 style while A1 is in A1 style"},
+		`=SUM(R2C2,B2:C3)+R1`: {"B2:C3 is in A1 style while R2C2 is in R1C1 style"},
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, issue := range p.Lint() {
+			got = append(got, issue.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Lint(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestUnionRoundTrip(t *testing.T) {
+	f := `=SUM((A1,B1),C1)`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStart),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeOperatorInfix, TokenSubTypeUnion),
+		fToken("B1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStop),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("C1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	for _, formula := range []string{f, `=(A1,B1,C1)`} {
+		p.Parse(formula)
+		if got := "=" + p.Render(); got != formula {
+			t.Errorf("Render(%s) = %s", formula, got)
+		}
+		if got := RenderAST(mustParseAST(t, formula)); got != formula {
+			t.Errorf("RenderAST(%s) = %s", formula, got)
+		}
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=IF(AND(A1>0,B1<10),1,0)`: "A1 > 0|B1 < 10",
+		`=A1+1>=SUM(B1:B2)*2`:      "A1+1 >= SUM(B1:B2)*2",
+		`=A1=B1=C1`:                "A1 = B1|A1=B1 = C1",
+		`=IF((A1<>"x")+(B1<1),1)`:  `A1 <> "x"|B1 < 1`,
+		`=(A1)+1>(B1)`:             "(A1)+1 > (B1)",
+		`=(A1>B1)=(C1>D1)`:         "A1 > B1|(A1>B1) = (C1>D1)|C1 > D1",
+		`=IF(A1,,)<>{1,2}`:         "IF(A1,,) <> {1,2}",
+		`=SUM(A1)`:                 "",
+		`=A1>`:                     "",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		var got []string
+		for _, c := range p.Comparisons() {
+			got = append(got, strings.Join(renderPieces(c.Left, LocaleUS), "")+" "+c.Op.TValue+" "+strings.Join(renderPieces(c.Right, LocaleUS), ""))
+		}
+		if strings.Join(got, "|") != want {
+			t.Errorf("Comparisons(%s) = %q, want %q", formula, strings.Join(got, "|"), want)
+		}
+	}
+	p := ExcelParser()
+	p.EmitEOF = true
+	p.Parse(`=A1>B1`)
+	if got := p.Comparisons(); len(got) != 1 || len(got[0].Right) != 1 || got[0].Right[0].TValue != "B1" {
+		t.Errorf("Comparisons(=A1>B1) with EmitEOF = %v", got)
+	}
+}
+
+func TestParseTableReference(t *testing.T) {
+	f := `=[1]!Table1[Amount]`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("[1]!Table1[Amount]", TokenTypeOperand, TokenSubTypeRange),
+	})
+	if issues := p.Lint(); len(issues) != 0 {
+		t.Errorf("Lint(%s) = %v, want none", f, issues)
+	}
+	for value, want := range map[string][4]string{
+		`[1]!Table1[Amount]`:      {"1", "Table1", "[Amount]", "true"},
+		`Table1[@[Sales Amount]]`: {"", "Table1", "[@[Sales Amount]]", "true"},
+		`@Table1[Amount]`:         {"", "Table1", "[Amount]", "true"},
+		`[@Qty]`:                  {"", "", "[@Qty]", "true"},
+		`[1]Sheet1!A1`:            {"", "", "", "false"},
+		`A1`:                      {"", "", "", "false"},
+	} {
+		workbook, table, specifier, ok := ParseTableReference(value)
+		if got := [4]string{workbook, table, specifier, fmt.Sprint(ok)}; got != want {
+			t.Errorf("ParseTableReference(%s) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestReferencedColumns(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=SUM(A1:C1)+E5`:            "A B C E",
+		`=SUM(D:B,Sheet2!$B$2,1:3)`: "B C D",
+		`=C3:A1+AA1`:                "A B C AA",
+		`=TaxRate*"A1"`:             "",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := strings.Join(p.ReferencedColumns(), " "); got != want {
+			t.Errorf("ReferencedColumns(%s) = %q, want %q", formula, got, want)
+		}
+	}
+}
+
+func TestPercentOfSubexpression(t *testing.T) {
+	f := `=(A1+B1)%`
+	p := ExcelParser()
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("", TokenTypeSubexpression, TokenSubTypeStart),
+		fToken("A1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("+", TokenTypeOperatorInfix, TokenSubTypeMath),
+		fToken("B1", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeSubexpression, TokenSubTypeStop),
+		fToken("%", TokenTypeOperatorPostfix, ""),
+	})
+	if got := "=" + p.Render(); got != f {
+		t.Errorf("Render(%s) = %s", f, got)
+	}
+	if _, err := p.ParseChecked(f); err != nil {
+		t.Errorf("ParseChecked(%s) error: %v", f, err)
+	}
+	n := mustParseAST(t, f)
+	if n.Token.TValue != "%" || len(n.Children) != 1 || n.Children[0].Token.TValue != "+" {
+		t.Errorf("ParseAST(%s) root = %v", f, n.Token)
+	}
+	if got := RenderAST(n); got != f {
+		t.Errorf("RenderAST(%s) = %s", f, got)
+	}
+	p.Parse(`=A1/(2-2)%`)
+	if issues := p.Lint(); len(issues) != 1 {
+		t.Errorf("Lint(=A1/(2-2)%%) = %v, want division by zero", issues)
+	}
+}
+
+func TestMultiLineEndings(t *testing.T) {
+	p := ExcelParser()
+	p.MultiLine = true
+	unix := p.Parse("=IF(A1,\n  B1,\n  C1)")
+	windows := p.Parse("=IF(A1,\r\n  B1,\r\n  C1)")
+	if len(unix) != len(windows) {
+		t.Fatalf("Parse() with \\r\\n = %v, want %v", windows, unix)
+	}
+	for i := range unix {
+		if unix[i] != windows[i] {
+			t.Errorf("token %d with \\r\\n = %#v, want %#v", i, windows[i], unix[i])
+		}
+	}
+	if p.Formula != "=IF(A1,\n  B1,\n  C1)" {
+		t.Errorf("Formula = %q, want line endings normalized", p.Formula)
+	}
+}
+
+func TestArgumentSeparatorOffsets(t *testing.T) {
+	for formula, want := range map[string]string{
+		`=IF(A1,1,0)`:              "[6 8]",
+		`=IF(A1,SUM(B1,B2),{1,2})`: "[6 17]",
+		`=1+ROUND(A1, 2)`:          "[11]",
+		`=A1+B1`:                   "[]",
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := fmt.Sprint(p.ArgumentSeparatorOffsets()); got != want {
+			t.Errorf("ArgumentSeparatorOffsets(%s) = %s, want %s", formula, got, want)
+		}
+	}
+}
+
+func TestSafeToInline(t *testing.T) {
+	for formula, want := range map[string]bool{
+		`=A1+1`:                        true,
+		`=SUM(A1:A3)*{1,2}`:            true,
+		`=NOW()+1`:                     false,
+		`=IF(A1,_xlfn.RANDARRAY(2),0)`: false,
+		`=rtd("x",,"y")`:               false,
+		`=SORT(A1:A3)`:                 false,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.SafeToInline(); got != want {
+			t.Errorf("SafeToInline(%s) = %t, want %t", formula, got, want)
+		}
+	}
+}
+
+func TestArrayPercentages(t *testing.T) {
+	f := `={10%,20%}`
+	p := ExcelParser()
+	parsed := p.Parse(f)
+	p.FoldPercentages()
+	if len(parsed) != 9 || parsed[3].TValue != "%" || parsed[8].TSubType != TokenSubTypeStop {
+		t.Errorf("FoldPercentages(%s) changed the tokens returned by Parse: %v", f, parsed)
+	}
+	assertTokens(t, f, p.Tokens.Items, []Token{
+		fToken("ARRAY", TokenTypeFunction, TokenSubTypeStart),
+		fToken("ARRAYROW", TokenTypeFunction, TokenSubTypeStart),
+		fToken("10%", TokenTypeOperand, TokenSubTypePercent),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("20%", TokenTypeOperand, TokenSubTypePercent),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if got := p.RenderWithLocale(LocaleUS); got != f {
+		t.Errorf("RenderWithLocale(%s) = %s", f, got)
+	}
+	if err := p.ValidateArrays(); err != nil {
+		t.Errorf("ValidateArrays(%s) error: %v", f, err)
+	}
+	p.Locale = LocaleDE
+	p.Parse(`={1,5%\2%}`)
+	p.FoldPercentages()
+	if got := p.RenderWithLocale(LocaleUS); got != `={1.5%,2%}` {
+		t.Errorf("RenderWithLocale(={1,5%%\\2%%}) = %s", got)
+	}
+	p = ExcelParser()
+	p.Parse(`=A1%+(B1)%+5%%`)
+	p.FoldPercentages()
+	if got := p.TokensOfSubtype(TokenSubTypePercent); len(got) != 1 || got[0].TValue != "5%" {
+		t.Errorf("TokensOfSubtype(Percent) = %v, want 5%%", got)
+	}
+	if got := p.RenderWithLocale(LocaleUS); got != `=A1%+(B1)%+5%%` {
+		t.Errorf("RenderWithLocale(=A1%%+(B1)%%+5%%%%) = %s", got)
+	}
+	p.Parse(`=A1/0%`)
+	p.FoldPercentages()
+	if issues := p.Lint(); len(issues) != 1 {
+		t.Errorf("Lint(=A1/0%%) = %v, want division by zero", issues)
+	}
+}
+
+func TestSwapReferences(t *testing.T) {
+	p := ExcelParser()
+	p.Parse(`=A1+B1*A1`)
+	if got, err := p.SwapReferences("A1", "B1"); err != nil || got != `=B1+A1*B1` {
+		t.Errorf("SwapReferences(A1, B1) = %s, %v, want =B1+A1*B1", got, err)
+	}
+	p.Parse(`=SUM(a1,Sheet1!A1,"A1",TaxRate)`)
+	if got, err := p.SwapReferences("A1", "taxrate"); err != nil || got != `=SUM(taxrate,Sheet1!A1,"A1",A1)` {
+		t.Errorf("SwapReferences(A1, taxrate) = %s, %v", got, err)
+	}
+	for _, c := range [][2]string{{"", "B1"}, {"A1", "a1"}} {
+		if _, err := p.SwapReferences(c[0], c[1]); err == nil {
+			t.Errorf("SwapReferences(%q, %q) error = nil", c[0], c[1])
+		}
+	}
+}
+
+func TestRangeBetweenFunctions(t *testing.T) {
+	f := `=INDEX(A:A,1):INDEX(A:A,5)`
+	p := ExcelParser()
+	p.SplitRanges = true
+	assertTokens(t, f, p.Parse(f), []Token{
+		fToken("INDEX", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A", TokenTypeOperand, TokenSubTypeRange),
+		fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+		fToken("A", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("1", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+		fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+		fToken("INDEX", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A", TokenTypeOperand, TokenSubTypeRange),
+		fToken(":", TokenTypeOperatorInfix, TokenSubTypeRange),
+		fToken("A", TokenTypeOperand, TokenSubTypeRange),
+		fToken(",", TokenTypeArgument, ""),
+		fToken("5", TokenTypeOperand, TokenSubTypeNumber),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	if got := "=" + p.Render(); got != f {
+		t.Errorf("Render(%s) = %s", f, got)
+	}
+	n, err := p.ast()
+	if err != nil {
+		t.Fatalf("ast(%s) error: %v", f, err)
+	}
+	if n.Token.TValue != ":" || len(n.Children) != 2 || n.Children[0].Token.TValue != "INDEX" || n.Children[1].Token.TValue != "INDEX" {
+		t.Errorf("ast(%s) root = %v", f, n.Token)
+	}
+	if got := RenderAST(n); got != f {
+		t.Errorf("RenderAST(%s) = %s", f, got)
+	}
+	if got := p.Parse(`=(A1):OFFSET(A1,2,2)`); len(got) != 11 || got[3].TValue != ":" || got[4].TValue != "OFFSET" {
+		t.Errorf("Parse(=(A1):OFFSET(A1,2,2)) = %v", got)
+	}
+}
+
+func TestMaxArgumentCount(t *testing.T) {
+	for formula, want := range map[string]int{
+		`=IF(A1,SUM(B1,C1,D1),0)`: 3,
+		`=NOW()+PI()`:             0,
+		`=SUM({1,2,3,4},A1)`:      2,
+		`=A1+1`:                   0,
+		`=IF(A1,,)`:               3,
+		`=SUM(A1,(B1`:             0,
+	} {
+		p := ExcelParser()
+		p.Parse(formula)
+		if got := p.MaxArgumentCount(); got != want {
+			t.Errorf("MaxArgumentCount(%s) = %d, want %d", formula, got, want)
+		}
+	}
+}
+
+func TestIntersectionRoundTrip(t *testing.T) {
+	for _, formula := range []string{
+		`=A1:A10 B1:D1`,
+		`=A1:A10 B1:D1 C1:C5`,
+		`=SUM(A1:B2 B1:C2,C3)`,
+		`=(A1:B2,B1:C2) C1:C3`,
+		`=A1:A10 (B1:D1,C1)`,
+		`=-A1:A10 B1:D1%`,
+		`=Sheet1!A1:B2 'My Sheet'!B1:C2`,
+		`=INDEX(A:A,1) B1:B3`,
+		`=B1# A1:A10`,
+	} {
+		for _, options := range [][2]bool{{false, false}, {true, false}, {false, true}} {
+			p := ExcelParser()
+			p.SplitRanges, p.SpillAsOperator = options[0], options[1]
+			p.Parse(formula)
+			if got := "=" + p.Render(); got != formula {
+				t.Errorf("Render(%s) with SplitRanges %t, SpillAsOperator %t = %s", formula, options[0], options[1], got)
+			}
+		}
+		if got := RenderAST(mustParseAST(t, formula)); got != formula {
+			t.Errorf("RenderAST(%s) = %s", formula, got)
+		}
+	}
+	p := ExcelParser()
+	p.Parse(`= A1:A10   B1:D1 `)
+	if got := "=" + p.Render(); got != `=A1:A10 B1:D1` {
+		t.Errorf("Render(= A1:A10   B1:D1 ) = %s, want =A1:A10 B1:D1", got)
+	}
+}
+
+func TestParseMultiple(t *testing.T) {
+	got := ParseMultiple("=SUM(A1:A3)\n=\"a\nb\"&'My\nSheet'!B1")
+	if len(got) != 2 {
+		t.Fatalf("ParseMultiple() = %v, want 2 formulas", got)
+	}
+	assertTokens(t, "=SUM(A1:A3)", got[0], []Token{
+		fToken("SUM", TokenTypeFunction, TokenSubTypeStart),
+		fToken("A1:A3", TokenTypeOperand, TokenSubTypeRange),
+		fToken("", TokenTypeFunction, TokenSubTypeStop),
+	})
+	assertTokens(t, "=\"a\nb\"&'My\nSheet'!B1", got[1], []Token{
+		fToken("a\nb", TokenTypeOperand, TokenSubTypeText),
+		fToken("&", TokenTypeOperatorInfix, TokenSubTypeConcatenation),
+		fToken("'My\nSheet'!B1", TokenTypeOperand, TokenSubTypeRange),
+	})
+	if got := ParseMultiple("=A1+1\r\n\r\nB2*2\r\n"); len(got) != 2 || len(got[1]) != 3 || got[1][2].TValue != "2" {
+		t.Errorf("ParseMultiple() with \\r\\n = %v, want 2 formulas", got)
+	}
+	if got := ParseMultiple(""); len(got) != 0 {
+		t.Errorf("ParseMultiple(\"\") = %v, want none", got)
+	}
+}
+
+func TestUnquoteSheetNames(t *testing.T) {
+	for formula, want := range map[string][2]string{
+		`='My Sheet'!A1`:        {`'My Sheet'!A1`, `My Sheet!A1`},
+		`='It''s'!A1:B2`:        {`'It''s'!A1:B2`, `It's!A1:B2`},
+		`=SUM('[1]Sheet 1'!C3)`: {`'[1]Sheet 1'!C3`, `[1]Sheet 1!C3`},
+	} {
+		for i, unquote := range []bool{false, true} {
+			p := ExcelParser()
+			p.UnquoteSheetNames = unquote
+			var got string
+			for _, tk := range p.Parse(formula) {
+				if tk.TType == TokenTypeOperand {
+					got = tk.TValue
+				}
+			}
+			if got != want[i] {
+				t.Errorf("Parse(%s) with UnquoteSheetNames %t = %s, want %s", formula, unquote, got, want[i])
+			}
+		}
+	}
+}